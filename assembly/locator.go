@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/graduate-work-mirea/api-gateway/config"
 	"github.com/graduate-work-mirea/api-gateway/controller"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
 	"github.com/graduate-work-mirea/api-gateway/repository"
 	"github.com/graduate-work-mirea/api-gateway/server"
 	"github.com/graduate-work-mirea/api-gateway/service"
@@ -18,6 +19,7 @@ type ServiceLocator struct {
 	cacheRepo  repository.CacheRepository
 	service    service.Service
 	controller *controller.Controller
+	keySet     *middleware.KeySet
 	server     *server.Server
 	router     *gin.Engine
 }
@@ -32,6 +34,12 @@ func NewServiceLocator(cfg *config.Config) *ServiceLocator {
 
 	log.Println("Creating new service locator")
 
+	// Only trust X-Forwarded-For from the configured proxies, the client IP keys rate limits and login lockouts
+	log.Printf("Trusted proxies: %v", cfg.Server.TrustedProxies)
+	if err := locator.router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+
 	// Initialize services
 	locator.initServices()
 
@@ -67,12 +75,21 @@ func (l *ServiceLocator) initServices() {
 
 	// Create controller and pass the router
 	log.Println("Creating controller...")
-	l.controller = controller.NewController(l.service, l.router)
+	l.controller = controller.NewController(l.service, l.router, l.config)
 	log.Println("Controller created successfully")
 
+	// Create JWT key set
+	log.Println("Creating JWT key set...")
+	keySet, err := middleware.NewKeySet(l.config)
+	if err != nil {
+		log.Fatalf("Failed to create JWT key set: %v", err)
+	}
+	l.keySet = keySet
+	log.Println("JWT key set created successfully")
+
 	// Create server and pass the router
 	log.Println("Creating server...")
-	l.server = server.NewServer(l.config, l.controller, l.router)
+	l.server = server.NewServer(l.config, l.controller, l.keySet, l.service, l.router)
 	log.Println("Server created successfully")
 
 	log.Println("All services initialized successfully")
@@ -1,10 +1,22 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// placeholderJWTSecret is the example JWT_SECRET of older configurations, it is treated as unset
+const placeholderJWTSecret = "your_secret_key_here"
+
 // Config holds all the configuration for the application
 type Config struct {
 	Server     ServerConfig
@@ -13,18 +25,280 @@ type Config struct {
 	DB         DatabaseConfig
 	CacheSize  int
 	JWTSecret  string
+	JWT        JWTConfig
+	RateLimit  RateLimitConfig
+	LoginGuard LoginGuardConfig
 	CorsOrigin string
+
+	// InternalToken configures the gateway-signed token forwarded to upstream services
+	InternalToken InternalTokenConfig
+
+	// ProxyRoutes are forwarded as they are to an upstream service, without a typed handler
+	ProxyRoutes []ProxyRoute
+
+	// HealthCheck configures the background probes of the dependencies
+	HealthCheck HealthCheckConfig
+
+	// Ejection configures the passive ejection of failing instances of every upstream service
+	Ejection EjectionConfig
+
+	// CircuitBreaker configures the circuit breaker of every upstream service
+	CircuitBreaker CircuitBreakerConfig
+
+	// Retry configures the retries of idempotent upstream calls
+	Retry RetryConfig
+
+	// Hedge configures the hedging of prediction calls
+	Hedge HedgeConfig
+
+	// Batch configures the batch prediction endpoint
+	Batch BatchConfig
+
+	// TrainingTimeout bounds a background model training job
+	TrainingTimeout time.Duration
+	// TrainingHeartbeatInterval is how often the gateway instance running a training job records that it is alive
+	TrainingHeartbeatInterval time.Duration
+	// TrainingStaleAfter is how long an unfinished training job goes without a heartbeat before it is failed
+	TrainingStaleAfter time.Duration
+
+	// PredictionCacheTTL is how long prediction responses are cached, 0 disables the cache
+	PredictionCacheTTL time.Duration
+	// ModelVersionSyncInterval is how often the model version is reloaded from the database,
+	// so the models trained through other gateway instances replace the cached predictions
+	ModelVersionSyncInterval time.Duration
+
+	// APIKeyCacheTTL is how long API key lookups are cached, which bounds how long
+	// a key revoked on another gateway instance keeps working here
+	APIKeyCacheTTL time.Duration
+
+	// RevocationSyncInterval is how often revoked tokens are reloaded from the database,
+	// so revocations made by other gateway instances take effect
+	RevocationSyncInterval time.Duration
 }
 
 // ServerConfig holds the configuration for the API Gateway server
 type ServerConfig struct {
 	Port string
+	// TrustedProxies lists the IPs and CIDRs of the proxies whose X-Forwarded-For header is trusted,
+	// the client IP is the remote address of the connection when empty
+	TrustedProxies []string
 }
 
+// Load balancing strategies
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastOutstanding = "least_outstanding"
+	StrategyWeighted         = "weighted"
+)
+
 // ServiceConfig holds the configuration for external services
 type ServiceConfig struct {
 	Host string
 	Port string
+	// Endpoints lists the instances of the service, it holds Host and Port unless several are configured
+	Endpoints []Endpoint
+	// Strategy selects how calls are spread over the endpoints
+	Strategy string
+	// Timeouts bounds the calls to the service
+	Timeouts TimeoutConfig
+	// Transport configures the connection pool of the service client
+	Transport TransportConfig
+	// Bulkhead limits the concurrent calls to the service
+	Bulkhead BulkheadConfig
+}
+
+// BulkheadConfig holds the concurrency limit of the calls to an external service
+type BulkheadConfig struct {
+	// MaxConcurrent is the number of calls in flight to all instances, 0 disables the limit
+	MaxConcurrent int
+	// MaxQueued is the number of calls waiting for a free slot, the others are rejected right away
+	MaxQueued int
+	// QueueTimeout is how long a call waits for a free slot before it is rejected
+	QueueTimeout time.Duration
+}
+
+// TimeoutConfig holds the timeouts of the calls to an external service, 0 disables a timeout
+type TimeoutConfig struct {
+	// Connect bounds establishing a connection to an instance
+	Connect time.Duration
+	// ResponseHeader bounds the wait for the response headers once the request is sent
+	ResponseHeader time.Duration
+	// Total bounds a whole call, including reading the response body
+	Total time.Duration
+	// OperationResponseHeader overrides ResponseHeader per operation, like "predict" or "train"
+	OperationResponseHeader map[string]time.Duration
+	// OperationTotal overrides Total per operation
+	OperationTotal map[string]time.Duration
+}
+
+// TransportConfig holds the connection pool settings of the client of an external service
+type TransportConfig struct {
+	// MaxIdleConns is the number of idle connections kept over all instances
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the number of idle connections kept per instance
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections per instance, 0 means no limit
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before it is closed
+	IdleConnTimeout time.Duration
+	// KeepAlive is the interval of TCP keep-alive probes, a negative value disables them
+	KeepAlive time.Duration
+}
+
+// Endpoint is a single instance of an external service
+type Endpoint struct {
+	Host string
+	Port string
+	// Weight is the share of calls of the instance with the weighted strategy
+	Weight int
+}
+
+// ProxyRoute is a gateway route forwarded to an upstream service by the generic reverse proxy
+type ProxyRoute struct {
+	Method string `json:"method"`
+	// Path is the gateway route, it may contain :name and *name parameters
+	Path string `json:"path"`
+	// Upstream is the service the route is forwarded to: "auth" or "ml"
+	Upstream string `json:"upstream"`
+	// Rewrite is the upstream path, its :name and *name parameters are filled from the route.
+	// The request path is forwarded unchanged when it is empty.
+	Rewrite      string   `json:"rewrite"`
+	AuthRequired bool     `json:"auth_required"`
+	Roles        []string `json:"roles"`
+	Scopes       []string `json:"scopes"`
+	// RawTimeout is the duration, like "30s", that bounds a proxied call instead of the upstream total timeout
+	RawTimeout string `json:"timeout"`
+	// Timeout is the parsed RawTimeout, 0 when it is empty
+	Timeout time.Duration `json:"-"`
+}
+
+// HealthCheckConfig holds the configuration for the background dependency probes
+type HealthCheckConfig struct {
+	// Interval is how often every dependency is probed
+	Interval time.Duration
+	// Timeout bounds a single probe
+	Timeout time.Duration
+	// AuthPath is the Auth service endpoint probed with GET
+	AuthPath string
+}
+
+// EjectionConfig holds the configuration for the passive ejection of failing service instances
+type EjectionConfig struct {
+	// ConsecutiveFailures is the number of failed calls in a row that ejects an instance, 0 disables ejection
+	ConsecutiveFailures int
+	// Duration is how long an ejected instance receives no calls
+	Duration time.Duration
+}
+
+// JWTConfig holds the configuration for JWT verification
+type JWTConfig struct {
+	// Algorithms lists the accepted signing algorithms
+	Algorithms []string
+	// HMACKeys maps key IDs to HMAC secrets, so several secrets are accepted during a rotation
+	HMACKeys map[string]string
+	// ActiveKeyID selects the HMAC key for tokens without a kid, JWTSecret is used when empty
+	// and HMAC signed tokens without a kid are rejected when JWTSecret is empty too
+	ActiveKeyID string
+	// PublicKeyFile is a PEM file with the RSA or EC key for tokens without a kid
+	PublicKeyFile string
+	// JWKSURL is an http(s) URL or a local file path of a JWKS document
+	JWKSURL             string
+	JWKSRefreshInterval time.Duration
+	// Issuer is the expected iss claim, it is not checked when empty
+	Issuer string
+	// Audience must be one of the aud claim values, it is not checked when empty
+	Audience string
+	// Leeway is the clock skew allowed when checking the exp, nbf and iat claims
+	Leeway time.Duration
+}
+
+// RateLimitConfig holds the configuration for request rate limiting
+type RateLimitConfig struct {
+	// User limits authenticated requests per user ID
+	User RateLimit
+	// IP limits anonymous requests per client IP
+	IP RateLimit
+	// Routes overrides the limit of single routes, keyed by "METHOD /path"
+	Routes map[string]RateLimit
+}
+
+// RateLimit is a token bucket refilled at Rate requests per second holding up to Burst requests
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// LoginGuardConfig holds the configuration for brute-force protection of the login route
+type LoginGuardConfig struct {
+	// MaxFailuresPerEmail and MaxFailuresPerIP are the failed attempts that trigger a lockout
+	MaxFailuresPerEmail int
+	MaxFailuresPerIP    int
+	// LockoutDuration is how long a locked email or IP is rejected
+	LockoutDuration time.Duration
+	// BaseDelay is the wait required after the first failure, doubled by every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// FailureWindow is how long failures are remembered after the last one
+	FailureWindow time.Duration
+}
+
+// InternalTokenConfig holds the configuration for the gateway-signed identity token
+type InternalTokenConfig struct {
+	Enabled bool
+	// Secret signs the token, it is dedicated to internal tokens so upstreams never hold the user token keys
+	Secret string
+	// KeyID is set as the kid header of the token when not empty
+	KeyID  string
+	Issuer string
+	TTL    time.Duration
+}
+
+// CircuitBreakerConfig holds the configuration for the upstream circuit breakers
+type CircuitBreakerConfig struct {
+	// FailureRate is the share of failed calls in a window, from 0 to 1, that opens the circuit
+	FailureRate float64
+	// MinRequests is the number of calls in a window required before the failure rate is evaluated
+	MinRequests int
+	// Window is how long calls are counted before the counters are reset
+	Window time.Duration
+	// OpenTimeout is how long an open circuit rejects calls before letting probes through
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probe calls that have to succeed to close the circuit
+	HalfOpenRequests int
+}
+
+// RetryConfig holds the configuration for retrying idempotent upstream calls
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts including the first one, 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the backoff ceiling before the first retry, doubled by every further one up to MaxDelay.
+	// The actual delay is picked at random below the ceiling.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget bounds the total time of all attempts and backoffs of a call
+	Budget time.Duration
+}
+
+// HedgeConfig holds the configuration for hedging prediction calls: when an attempt is slow,
+// another one is sent to a different instance and the first success is used
+type HedgeConfig struct {
+	Enabled bool
+	// Delay is how long an attempt runs before it is hedged, 0 uses the recent p95 latency of the operation
+	Delay time.Duration
+	// MinDelay is the lowest latency-based delay, so a fast service is not hedged all the time
+	MinDelay time.Duration
+	// MaxHedges is the number of extra attempts a call may send
+	MaxHedges int
+}
+
+// BatchConfig holds the configuration for batch predictions
+type BatchConfig struct {
+	// MaxItems is the largest number of items of a batch
+	MaxItems int
+	// Concurrency is the number of items of a batch predicted at the same time
+	Concurrency int
+	// MaxUploadBytes is the largest size of a CSV upload request
+	MaxUploadBytes int64
 }
 
 // DatabaseConfig holds the configuration for the database
@@ -41,18 +315,155 @@ type DatabaseConfig struct {
 func LoadConfig() (*Config, error) {
 	cacheSize, _ := strconv.Atoi(getEnv("CACHE_SIZE", "1000"))
 
+	hmacKeys, err := parseKeyring(getEnv("JWT_HMAC_KEYS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("JWT_HMAC_KEYS: %w", err)
+	}
+	activeKeyID := getEnv("JWT_ACTIVE_KEY_ID", "")
+	if _, exists := hmacKeys[activeKeyID]; activeKeyID != "" && !exists {
+		return nil, fmt.Errorf("JWT_ACTIVE_KEY_ID: key %q is not in JWT_HMAC_KEYS", activeKeyID)
+	}
+
+	// The example secret is public, tokens signed with it must never be accepted
+	jwtSecret := getEnv("JWT_SECRET", "")
+	if jwtSecret == placeholderJWTSecret {
+		jwtSecret = ""
+	}
+	publicKeyFile := getEnv("JWT_PUBLIC_KEY_FILE", "")
+	jwksURL := getEnv("JWT_JWKS_URL", "")
+	if jwtSecret == "" && len(hmacKeys) == 0 && publicKeyFile == "" && jwksURL == "" {
+		return nil, errors.New("JWT_SECRET: required unless JWT_HMAC_KEYS, JWT_PUBLIC_KEY_FILE or JWT_JWKS_URL is set")
+	}
+
+	// Gateways verifying public-key signed tokens only accept HMAC signed ones when asked to
+	defaultAlgorithms := "HS256,RS256,ES256"
+	if publicKeyFile != "" || jwksURL != "" {
+		defaultAlgorithms = "RS256,ES256"
+	}
+
+	leeway := getEnvDuration("JWT_LEEWAY", 30*time.Second)
+	if leeway < 0 {
+		return nil, fmt.Errorf("JWT_LEEWAY: must not be negative, got %v", leeway)
+	}
+
+	auth, err := loadServiceConfig("AUTH_SERVICE", "localhost", "8080")
+	if err != nil {
+		return nil, err
+	}
+	ml, err := loadServiceConfig("ML_SERVICE", "localhost", "6785")
+	if err != nil {
+		return nil, err
+	}
+
+	proxyRoutes, err := loadProxyRoutes(getEnv("PROXY_ROUTES_FILE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("PROXY_ROUTES_FILE: %w", err)
+	}
+
+	healthCheckInterval := getEnvDuration("HEALTH_CHECK_INTERVAL", 10*time.Second)
+	if healthCheckInterval <= 0 {
+		return nil, fmt.Errorf("HEALTH_CHECK_INTERVAL: must be positive, got %v", healthCheckInterval)
+	}
+
+	circuitBreaker := CircuitBreakerConfig{
+		FailureRate:      getEnvFloat("CIRCUIT_BREAKER_FAILURE_RATE", 0.5),
+		MinRequests:      getEnvInt("CIRCUIT_BREAKER_MIN_REQUESTS", 10),
+		Window:           getEnvDuration("CIRCUIT_BREAKER_WINDOW", 30*time.Second),
+		OpenTimeout:      getEnvDuration("CIRCUIT_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		HalfOpenRequests: getEnvInt("CIRCUIT_BREAKER_HALF_OPEN_REQUESTS", 3),
+	}
+	if circuitBreaker.FailureRate <= 0 || circuitBreaker.FailureRate > 1 {
+		return nil, fmt.Errorf("CIRCUIT_BREAKER_FAILURE_RATE: must be in (0, 1], got %v", circuitBreaker.FailureRate)
+	}
+	if circuitBreaker.MinRequests < 1 || circuitBreaker.HalfOpenRequests < 1 {
+		return nil, errors.New("CIRCUIT_BREAKER_MIN_REQUESTS and CIRCUIT_BREAKER_HALF_OPEN_REQUESTS: must be at least 1")
+	}
+	if circuitBreaker.Window <= 0 || circuitBreaker.OpenTimeout <= 0 {
+		return nil, errors.New("CIRCUIT_BREAKER_WINDOW and CIRCUIT_BREAKER_OPEN_TIMEOUT: must be positive")
+	}
+
+	retry := RetryConfig{
+		MaxAttempts: getEnvInt("RETRY_MAX_ATTEMPTS", 3),
+		BaseDelay:   getEnvDuration("RETRY_BASE_DELAY", 100*time.Millisecond),
+		MaxDelay:    getEnvDuration("RETRY_MAX_DELAY", time.Second),
+		Budget:      getEnvDuration("RETRY_BUDGET", 5*time.Second),
+	}
+	if retry.MaxAttempts < 1 {
+		return nil, fmt.Errorf("RETRY_MAX_ATTEMPTS: must be at least 1, got %d", retry.MaxAttempts)
+	}
+	if retry.BaseDelay <= 0 || retry.MaxDelay < retry.BaseDelay || retry.Budget <= 0 {
+		return nil, errors.New("RETRY_BASE_DELAY, RETRY_MAX_DELAY and RETRY_BUDGET: must be positive, with RETRY_MAX_DELAY not below RETRY_BASE_DELAY")
+	}
+
+	hedge := HedgeConfig{
+		Enabled:   getEnv("HEDGE_ENABLED", "false") == "true",
+		Delay:     getEnvDuration("HEDGE_DELAY", 0),
+		MinDelay:  getEnvDuration("HEDGE_MIN_DELAY", 20*time.Millisecond),
+		MaxHedges: getEnvInt("HEDGE_MAX_HEDGES", 1),
+	}
+	if hedge.Delay < 0 || hedge.MinDelay < 0 {
+		return nil, errors.New("HEDGE_DELAY and HEDGE_MIN_DELAY: must not be negative")
+	}
+	if hedge.MaxHedges < 1 {
+		return nil, fmt.Errorf("HEDGE_MAX_HEDGES: must be at least 1, got %d", hedge.MaxHedges)
+	}
+
+	trainingHeartbeatInterval := getEnvDuration("TRAINING_HEARTBEAT_INTERVAL", 15*time.Second)
+	trainingStaleAfter := getEnvDuration("TRAINING_STALE_AFTER", time.Minute)
+	if trainingHeartbeatInterval <= 0 || trainingStaleAfter <= trainingHeartbeatInterval {
+		return nil, errors.New("TRAINING_HEARTBEAT_INTERVAL and TRAINING_STALE_AFTER: must be positive, with TRAINING_STALE_AFTER above TRAINING_HEARTBEAT_INTERVAL")
+	}
+
+	batch := BatchConfig{
+		MaxItems:       getEnvInt("BATCH_MAX_ITEMS", 500),
+		Concurrency:    getEnvInt("BATCH_CONCURRENCY", 8),
+		MaxUploadBytes: int64(getEnvInt("BATCH_MAX_UPLOAD_BYTES", 10<<20)),
+	}
+	if batch.MaxItems < 1 || batch.Concurrency < 1 || batch.MaxUploadBytes < 1 {
+		return nil, errors.New("BATCH_MAX_ITEMS, BATCH_CONCURRENCY and BATCH_MAX_UPLOAD_BYTES: must be at least 1")
+	}
+
+	trustedProxies := getEnvList("TRUSTED_PROXIES", "")
+	for _, proxy := range trustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: invalid IP or CIDR %q", proxy)
+		}
+	}
+
+	internalToken := InternalTokenConfig{
+		Enabled: getEnv("INTERNAL_TOKEN_ENABLED", "false") == "true",
+		Secret:  getEnv("INTERNAL_TOKEN_SECRET", ""),
+		KeyID:   getEnv("INTERNAL_TOKEN_KEY_ID", ""),
+		Issuer:  getEnv("INTERNAL_TOKEN_ISSUER", "api-gateway"),
+		TTL:     getEnvDuration("INTERNAL_TOKEN_TTL", time.Minute),
+	}
+	if internalToken.Enabled {
+		if internalToken.Secret == "" {
+			return nil, errors.New("INTERNAL_TOKEN_SECRET: required when INTERNAL_TOKEN_ENABLED is true")
+		}
+		if internalToken.Secret == jwtSecret || slices.Contains(slices.Collect(maps.Values(hmacKeys)), internalToken.Secret) {
+			return nil, errors.New("INTERNAL_TOKEN_SECRET: must differ from JWT_SECRET and the JWT_HMAC_KEYS secrets")
+		}
+	}
+	if internalToken.Issuer == "" {
+		return nil, errors.New("INTERNAL_TOKEN_ISSUER: must not be empty")
+	}
+	if internalToken.Issuer == getEnv("JWT_ISSUER", "") {
+		return nil, errors.New("INTERNAL_TOKEN_ISSUER: must differ from JWT_ISSUER, tokens with this issuer are rejected")
+	}
+
+	routeLimits, err := parseRouteLimits(getEnv("RATE_LIMIT_ROUTES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_ROUTES: %w", err)
+	}
+
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8000"),
-		},
-		Auth: ServiceConfig{
-			Host: getEnv("AUTH_SERVICE_HOST", "localhost"),
-			Port: getEnv("AUTH_SERVICE_PORT", "8080"),
-		},
-		ML: ServiceConfig{
-			Host: getEnv("ML_SERVICE_HOST", "localhost"),
-			Port: getEnv("ML_SERVICE_PORT", "6785"),
+			Port:           getEnv("SERVER_PORT", "8000"),
+			TrustedProxies: trustedProxies,
 		},
+		Auth: auth,
+		ML:   ml,
 		DB: DatabaseConfig{
 			Host:     getEnv("POSTGRES_HOST", "localhost"),
 			Port:     getEnv("POSTGRES_PORT", "5432"),
@@ -61,9 +472,62 @@ func LoadConfig() (*Config, error) {
 			Name:     getEnv("POSTGRES_DB", "marketplace_data"),
 			SSLMode:  getEnv("POSTGRES_SSLMODE", "disable"),
 		},
-		CacheSize:  cacheSize,
-		JWTSecret:  getEnv("JWT_SECRET", "your_secret_key_here"),
-		CorsOrigin: getEnv("CORS_ORIGIN", "http://localhost"),
+		CacheSize: cacheSize,
+		JWTSecret: jwtSecret,
+		JWT: JWTConfig{
+			Algorithms:          getEnvList("JWT_ALGORITHMS", defaultAlgorithms),
+			HMACKeys:            hmacKeys,
+			ActiveKeyID:         activeKeyID,
+			PublicKeyFile:       publicKeyFile,
+			JWKSURL:             jwksURL,
+			JWKSRefreshInterval: getEnvDuration("JWT_JWKS_REFRESH_INTERVAL", 10*time.Minute),
+			Issuer:              getEnv("JWT_ISSUER", ""),
+			Audience:            getEnv("JWT_AUDIENCE", ""),
+			Leeway:              leeway,
+		},
+		RateLimit: RateLimitConfig{
+			User: RateLimit{
+				Rate:  getEnvFloat("RATE_LIMIT_USER_RPS", 10),
+				Burst: getEnvInt("RATE_LIMIT_USER_BURST", 20),
+			},
+			IP: RateLimit{
+				Rate:  getEnvFloat("RATE_LIMIT_IP_RPS", 5),
+				Burst: getEnvInt("RATE_LIMIT_IP_BURST", 10),
+			},
+			Routes: routeLimits,
+		},
+		LoginGuard: LoginGuardConfig{
+			MaxFailuresPerEmail: getEnvInt("LOGIN_MAX_FAILURES_PER_EMAIL", 5),
+			MaxFailuresPerIP:    getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+			LockoutDuration:     getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			BaseDelay:           getEnvDuration("LOGIN_BASE_DELAY", time.Second),
+			MaxDelay:            getEnvDuration("LOGIN_MAX_DELAY", 30*time.Second),
+			FailureWindow:       getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		},
+		CorsOrigin:    getEnv("CORS_ORIGIN", "http://localhost"),
+		InternalToken: internalToken,
+		ProxyRoutes:   proxyRoutes,
+		HealthCheck: HealthCheckConfig{
+			Interval: healthCheckInterval,
+			Timeout:  getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+			AuthPath: getEnv("AUTH_SERVICE_HEALTH_PATH", "/health"),
+		},
+		Ejection: EjectionConfig{
+			ConsecutiveFailures: getEnvInt("UPSTREAM_EJECTION_FAILURES", 5),
+			Duration:            getEnvDuration("UPSTREAM_EJECTION_DURATION", 30*time.Second),
+		},
+		CircuitBreaker: circuitBreaker,
+		Retry:          retry,
+		Hedge:          hedge,
+		Batch:          batch,
+
+		TrainingTimeout:           getEnvDuration("TRAINING_TIMEOUT", time.Hour),
+		TrainingHeartbeatInterval: trainingHeartbeatInterval,
+		TrainingStaleAfter:        trainingStaleAfter,
+		PredictionCacheTTL:        getEnvDuration("PREDICTION_CACHE_TTL", 5*time.Minute),
+		ModelVersionSyncInterval:  getEnvDuration("MODEL_VERSION_SYNC_INTERVAL", 15*time.Second),
+		APIKeyCacheTTL:            getEnvDuration("API_KEY_CACHE_TTL", time.Minute),
+		RevocationSyncInterval:    getEnvDuration("REVOCATION_SYNC_INTERVAL", time.Minute),
 	}, nil
 }
 
@@ -75,3 +539,265 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvDuration retrieves a duration environment variable (e.g. "30s") or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList retrieves a comma-separated environment variable or returns a default value
+func getEnvList(key, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseKeyring parses a "kid1:secret1,kid2:secret2" list of HMAC keys
+func parseKeyring(value string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		kid, secret, found := strings.Cut(entry, ":")
+		if !found || kid == "" || secret == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected kid:secret", entry)
+		}
+		keys[kid] = secret
+	}
+	return keys, nil
+}
+
+// getEnvInt retrieves an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvFloat retrieves a float environment variable or returns a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// parseRouteLimits parses a "METHOD /path=rate:burst;METHOD /path=rate:burst" list of route limits
+func parseRouteLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		route, limit, found := strings.Cut(entry, "=")
+		rate, burst, foundBurst := strings.Cut(limit, ":")
+		if !found || !foundBurst {
+			return nil, fmt.Errorf("invalid route limit %q, expected METHOD /path=rate:burst", entry)
+		}
+
+		rateValue, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in %q: %w", entry, err)
+		}
+		burstValue, err := strconv.Atoi(burst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid burst in %q: %w", entry, err)
+		}
+		limits[strings.Join(strings.Fields(route), " ")] = RateLimit{Rate: rateValue, Burst: burstValue}
+	}
+	return limits, nil
+}
+
+// loadServiceConfig loads the configuration of an external service from the variables with the given prefix
+func loadServiceConfig(prefix, defaultHost, defaultPort string) (ServiceConfig, error) {
+	cfg := ServiceConfig{
+		Host:     getEnv(prefix+"_HOST", defaultHost),
+		Port:     getEnv(prefix+"_PORT", defaultPort),
+		Strategy: getEnv(prefix+"_LB_STRATEGY", StrategyRoundRobin),
+	}
+
+	switch cfg.Strategy {
+	case StrategyRoundRobin, StrategyLeastOutstanding, StrategyWeighted:
+	default:
+		return cfg, fmt.Errorf("%s_LB_STRATEGY: unknown strategy %q", prefix, cfg.Strategy)
+	}
+
+	endpoints, err := parseEndpoints(getEnv(prefix+"_ENDPOINTS", ""))
+	if err != nil {
+		return cfg, fmt.Errorf("%s_ENDPOINTS: %w", prefix, err)
+	}
+	if len(endpoints) == 0 {
+		endpoints = []Endpoint{{Host: cfg.Host, Port: cfg.Port, Weight: 1}}
+	}
+	cfg.Endpoints = endpoints
+
+	cfg.Timeouts = TimeoutConfig{
+		Connect:        getEnvDuration(prefix+"_CONNECT_TIMEOUT", 2*time.Second),
+		ResponseHeader: getEnvDuration(prefix+"_RESPONSE_HEADER_TIMEOUT", 10*time.Second),
+		Total:          getEnvDuration(prefix+"_REQUEST_TIMEOUT", 10*time.Second),
+	}
+	for name, value := range map[string]time.Duration{
+		"_CONNECT_TIMEOUT":         cfg.Timeouts.Connect,
+		"_RESPONSE_HEADER_TIMEOUT": cfg.Timeouts.ResponseHeader,
+		"_REQUEST_TIMEOUT":         cfg.Timeouts.Total,
+	} {
+		if value < 0 {
+			return cfg, fmt.Errorf("%s%s: must not be negative, got %v", prefix, name, value)
+		}
+	}
+	cfg.Timeouts.OperationResponseHeader, err = parseOperationTimeouts(getEnv(prefix+"_OPERATION_RESPONSE_HEADER_TIMEOUTS", ""))
+	if err != nil {
+		return cfg, fmt.Errorf("%s_OPERATION_RESPONSE_HEADER_TIMEOUTS: %w", prefix, err)
+	}
+	cfg.Timeouts.OperationTotal, err = parseOperationTimeouts(getEnv(prefix+"_OPERATION_REQUEST_TIMEOUTS", ""))
+	if err != nil {
+		return cfg, fmt.Errorf("%s_OPERATION_REQUEST_TIMEOUTS: %w", prefix, err)
+	}
+
+	cfg.Transport = TransportConfig{
+		MaxIdleConns:        getEnvInt(prefix+"_MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost: getEnvInt(prefix+"_MAX_IDLE_CONNS_PER_HOST", 32),
+		MaxConnsPerHost:     getEnvInt(prefix+"_MAX_CONNS_PER_HOST", 0),
+		IdleConnTimeout:     getEnvDuration(prefix+"_IDLE_CONN_TIMEOUT", 90*time.Second),
+		KeepAlive:           getEnvDuration(prefix+"_KEEP_ALIVE", 30*time.Second),
+	}
+	for name, value := range map[string]int{
+		"_MAX_IDLE_CONNS":          cfg.Transport.MaxIdleConns,
+		"_MAX_IDLE_CONNS_PER_HOST": cfg.Transport.MaxIdleConnsPerHost,
+		"_MAX_CONNS_PER_HOST":      cfg.Transport.MaxConnsPerHost,
+	} {
+		if value < 0 {
+			return cfg, fmt.Errorf("%s%s: must not be negative, got %d", prefix, name, value)
+		}
+	}
+	if cfg.Transport.IdleConnTimeout < 0 {
+		return cfg, fmt.Errorf("%s_IDLE_CONN_TIMEOUT: must not be negative, got %v", prefix, cfg.Transport.IdleConnTimeout)
+	}
+
+	cfg.Bulkhead = BulkheadConfig{
+		MaxConcurrent: getEnvInt(prefix+"_MAX_CONCURRENT_REQUESTS", 100),
+		MaxQueued:     getEnvInt(prefix+"_MAX_QUEUED_REQUESTS", 100),
+		QueueTimeout:  getEnvDuration(prefix+"_QUEUE_TIMEOUT", time.Second),
+	}
+	if cfg.Bulkhead.MaxConcurrent < 0 {
+		return cfg, fmt.Errorf("%s_MAX_CONCURRENT_REQUESTS: must not be negative, got %d", prefix, cfg.Bulkhead.MaxConcurrent)
+	}
+	if cfg.Bulkhead.MaxQueued < 0 {
+		return cfg, fmt.Errorf("%s_MAX_QUEUED_REQUESTS: must not be negative, got %d", prefix, cfg.Bulkhead.MaxQueued)
+	}
+	if cfg.Bulkhead.QueueTimeout < 0 {
+		return cfg, fmt.Errorf("%s_QUEUE_TIMEOUT: must not be negative, got %v", prefix, cfg.Bulkhead.QueueTimeout)
+	}
+
+	return cfg, nil
+}
+
+// parseOperationTimeouts parses an "operation1=duration1,operation2=duration2" list of timeouts
+func parseOperationTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, rawTimeout, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected operation=duration", entry)
+		}
+		timeout, err := time.ParseDuration(rawTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout of %s: %w", name, err)
+		}
+		if timeout < 0 {
+			return nil, fmt.Errorf("timeout of %s must not be negative, got %v", name, timeout)
+		}
+		timeouts[name] = timeout
+	}
+	return timeouts, nil
+}
+
+// parseEndpoints parses a "host1:port1,host2:port2=weight" list of service endpoints, the weight defaults to 1
+func parseEndpoints(value string) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		address, weight, hasWeight := strings.Cut(entry, "=")
+		endpoint := Endpoint{Weight: 1}
+		var found bool
+		endpoint.Host, endpoint.Port, found = strings.Cut(address, ":")
+		if !found || endpoint.Host == "" || endpoint.Port == "" {
+			return nil, fmt.Errorf("invalid endpoint %q, expected host:port or host:port=weight", entry)
+		}
+		if hasWeight {
+			weightValue, err := strconv.Atoi(weight)
+			if err != nil || weightValue < 1 {
+				return nil, fmt.Errorf("invalid weight in %q, expected a positive integer", entry)
+			}
+			endpoint.Weight = weightValue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// loadProxyRoutes reads the JSON array of proxy routes from a file, no file means no routes
+func loadProxyRoutes(path string) ([]ProxyRoute, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []ProxyRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parse routes: %w", err)
+	}
+
+	for i := range routes {
+		route := &routes[i]
+		route.Method = strings.ToUpper(route.Method)
+		switch route.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return nil, fmt.Errorf("route %d: unsupported method %q", i, route.Method)
+		}
+		if !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("route %d: path %q must start with /", i, route.Path)
+		}
+		if route.Rewrite != "" && !strings.HasPrefix(route.Rewrite, "/") {
+			return nil, fmt.Errorf("route %d: rewrite %q must start with /", i, route.Rewrite)
+		}
+		if route.Upstream != "auth" && route.Upstream != "ml" {
+			return nil, fmt.Errorf("route %d: unknown upstream %q, expected auth or ml", i, route.Upstream)
+		}
+		if !route.AuthRequired && (len(route.Roles) > 0 || len(route.Scopes) > 0) {
+			return nil, fmt.Errorf("route %d: roles and scopes require auth_required", i)
+		}
+		if route.RawTimeout != "" {
+			timeout, err := time.ParseDuration(route.RawTimeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("route %d: timeout %q must be a positive duration", i, route.RawTimeout)
+			}
+			route.Timeout = timeout
+		}
+	}
+	return routes, nil
+}
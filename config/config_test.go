@@ -0,0 +1,104 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoadConfigJWTKeys(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantErr        bool
+		wantSecret     string
+		wantAlgorithms []string
+	}{
+		{
+			name:    "no key source",
+			env:     map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "placeholder secret only",
+			env:     map[string]string{"JWT_SECRET": placeholderJWTSecret},
+			wantErr: true,
+		},
+		{
+			name:           "secret",
+			env:            map[string]string{"JWT_SECRET": "s3cr3t"},
+			wantSecret:     "s3cr3t",
+			wantAlgorithms: []string{"HS256", "RS256", "ES256"},
+		},
+		{
+			name:           "JWKS with placeholder secret",
+			env:            map[string]string{"JWT_SECRET": placeholderJWTSecret, "JWT_JWKS_URL": "/etc/gateway/jwks.json"},
+			wantAlgorithms: []string{"RS256", "ES256"},
+		},
+		{
+			name:           "public key file",
+			env:            map[string]string{"JWT_PUBLIC_KEY_FILE": "/etc/gateway/jwt.pem"},
+			wantAlgorithms: []string{"RS256", "ES256"},
+		},
+		{
+			name:           "explicit algorithms",
+			env:            map[string]string{"JWT_SECRET": "s3cr3t", "JWT_JWKS_URL": "/etc/gateway/jwks.json", "JWT_ALGORITHMS": "HS256,RS256"},
+			wantSecret:     "s3cr3t",
+			wantAlgorithms: []string{"HS256", "RS256"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"JWT_SECRET", "JWT_HMAC_KEYS", "JWT_PUBLIC_KEY_FILE", "JWT_JWKS_URL", "JWT_ALGORITHMS"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			cfg, err := LoadConfig()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadConfig succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.JWTSecret != tt.wantSecret {
+				t.Errorf("JWTSecret = %q, want %q", cfg.JWTSecret, tt.wantSecret)
+			}
+			if !slices.Equal(cfg.JWT.Algorithms, tt.wantAlgorithms) {
+				t.Errorf("Algorithms = %v, want %v", cfg.JWT.Algorithms, tt.wantAlgorithms)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInternalToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"disabled", map[string]string{}, false},
+		{"without secret", map[string]string{"INTERNAL_TOKEN_ENABLED": "true"}, true},
+		{"with the user token secret", map[string]string{"INTERNAL_TOKEN_ENABLED": "true", "INTERNAL_TOKEN_SECRET": "s3cr3t"}, true},
+		{"with a keyring secret", map[string]string{"INTERNAL_TOKEN_ENABLED": "true", "INTERNAL_TOKEN_SECRET": "old", "JWT_HMAC_KEYS": "k1:old"}, true},
+		{"with a dedicated secret", map[string]string{"INTERNAL_TOKEN_ENABLED": "true", "INTERNAL_TOKEN_SECRET": "internal"}, false},
+		{"with the user token issuer", map[string]string{"JWT_ISSUER": "auth", "INTERNAL_TOKEN_ISSUER": "auth"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "s3cr3t")
+			for _, name := range []string{"INTERNAL_TOKEN_ENABLED", "INTERNAL_TOKEN_SECRET", "INTERNAL_TOKEN_ISSUER", "JWT_HMAC_KEYS", "JWT_ISSUER"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			_, err := LoadConfig()
+			if tt.wantErr && err == nil {
+				t.Error("LoadConfig succeeded, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("LoadConfig: %v", err)
+			}
+		})
+	}
+}
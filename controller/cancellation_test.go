@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/repository"
+	"github.com/graduate-work-mirea/api-gateway/service"
+)
+
+// emptyDBRepository is a database without any data, enough for the service to start
+type emptyDBRepository struct {
+	repository.DBRepository
+}
+
+func (r *emptyDBRepository) GetAllPredictions(context.Context) (map[uuid.UUID][]model.PredictionHistory, error) {
+	return nil, nil
+}
+
+func (r *emptyDBRepository) GetRevokedTokens(context.Context) (map[string]time.Time, error) {
+	return nil, nil
+}
+
+func (r *emptyDBRepository) GetUserTokenRevocations(context.Context) (map[uuid.UUID]time.Time, error) {
+	return nil, nil
+}
+
+func (r *emptyDBRepository) GetLastSucceededTrainingJob(context.Context) (*model.TrainingJob, error) {
+	return nil, repository.ErrNotFound
+}
+
+func (r *emptyDBRepository) FailStaleTrainingJobs(context.Context, time.Time, string) (int64, error) {
+	return 0, nil
+}
+
+func (r *emptyDBRepository) Ping(context.Context) error {
+	return nil
+}
+
+// blockingUpstream starts an ML service that holds every call made on behalf of a user until
+// the gateway abandons it. It reports the received calls on received and the abandoned ones on aborted.
+func blockingUpstream(t *testing.T) (endpoint config.Endpoint, received, aborted <-chan struct{}) {
+	t.Helper()
+	receivedCalls := make(chan struct{}, 1)
+	abortedCalls := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Health probes are answered right away
+		if r.Header.Get(model.HeaderUserID) == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		receivedCalls <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			abortedCalls <- struct{}{}
+		case <-time.After(10 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parse server URL: %v", err)
+	}
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatalf("split server address: %v", err)
+	}
+	return config.Endpoint{Host: host, Port: port}, receivedCalls, abortedCalls
+}
+
+// newUpstreamService creates a service that calls the ML service at the endpoint
+func newUpstreamService(t *testing.T, endpoint config.Endpoint) service.Service {
+	t.Helper()
+	cfg := &config.Config{
+		CacheSize: 10,
+		ML: config.ServiceConfig{
+			Endpoints: []config.Endpoint{endpoint},
+			Timeouts:  config.TimeoutConfig{Total: 10 * time.Second},
+		},
+		Retry:       config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: 10 * time.Second},
+		HealthCheck: config.HealthCheckConfig{Interval: time.Hour, Timeout: time.Second},
+	}
+	cacheRepo, err := repository.NewCacheRepository(cfg)
+	if err != nil {
+		t.Fatalf("NewCacheRepository: %v", err)
+	}
+	return service.NewService(cfg, &emptyDBRepository{}, cacheRepo)
+}
+
+func TestCancelledRequestAbortsUpstreamCall(t *testing.T) {
+	endpoint, received, aborted := blockingUpstream(t)
+	router := newTestRouter(t, newUpstreamService(t, endpoint))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil).WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+signTestToken(t, middleware.RoleUser))
+	recorder := httptest.NewRecorder()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		router.ServeHTTP(recorder, request)
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream call not received")
+	}
+	// The client goes away while the upstream is still working on the call
+	cancel()
+
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream call not aborted")
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not answered")
+	}
+
+	if recorder.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", recorder.Code, statusClientClosedRequest)
+	}
+	// A cancelled call is not retried
+	select {
+	case <-received:
+		t.Error("cancelled call retried")
+	default:
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"cancelled", context.Canceled, statusClientClosedRequest},
+		{"wrapped cancellation", fmt.Errorf("ML service: %w", context.Canceled), statusClientClosedRequest},
+		{"deadline exceeded", context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{"circuit open", &service.CircuitOpenError{}, http.StatusServiceUnavailable},
+		{"bulkhead full", &service.BulkheadFullError{}, http.StatusServiceUnavailable},
+		{"other", errors.New("unexpected"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStatus(tt.err); got != tt.want {
+				t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,18 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
 	"github.com/graduate-work-mirea/api-gateway/middleware"
 	"github.com/graduate-work-mirea/api-gateway/model"
 	"github.com/graduate-work-mirea/api-gateway/service"
@@ -14,48 +22,125 @@ import (
 type Controller struct {
 	service service.Service
 	router  *gin.Engine
+	batch   config.BatchConfig
+}
+
+// statusClientClosedRequest is the non-standard status logged for requests the client abandoned
+const statusClientClosedRequest = 499
+
+// Page size of the training job list
+const (
+	defaultTrainingJobsLimit = 20
+	maxTrainingJobsLimit     = 100
+)
+
+// routePolicies declares which roles and API key scopes may call the protected routes
+var routePolicies = middleware.PolicyTable{
+	middleware.RouteKey(http.MethodPost, "/api/v1/predict"):         {Scopes: []string{model.ScopePredict}},
+	middleware.RouteKey(http.MethodPost, "/api/v1/predict/minimal"): {Scopes: []string{model.ScopePredict}},
+	middleware.RouteKey(http.MethodPost, "/api/v1/predict/batch"):   {Scopes: []string{model.ScopePredict}},
+	middleware.RouteKey(http.MethodPost, "/api/v1/predict/csv"):     {Scopes: []string{model.ScopePredict}},
+	middleware.RouteKey(http.MethodPost, "/api/v1/train"):           {Roles: []string{middleware.RoleAdmin}},
+	middleware.RouteKey(http.MethodGet, "/api/v1/train"):            {Roles: []string{middleware.RoleAdmin}},
+	middleware.RouteKey(http.MethodGet, "/api/v1/train/:id"):        {Roles: []string{middleware.RoleAdmin}},
+	middleware.RouteKey(http.MethodGet, "/api/v1/status"):           {Scopes: []string{model.ScopeStatus}},
+	middleware.RouteKey(http.MethodGet, "/api/v1/statistics/user"):  {Scopes: []string{model.ScopeStatistics}},
+
+	middleware.RouteKey(http.MethodPost, "/api/v1/admin/users/:id/revoke-tokens"): {Roles: []string{middleware.RoleAdmin}},
+	middleware.RouteKey(http.MethodGet, "/api/v1/admin/login-lockouts"):           {Roles: []string{middleware.RoleAdmin}},
+	middleware.RouteKey(http.MethodDelete, "/api/v1/admin/login-lockouts"):        {Roles: []string{middleware.RoleAdmin}},
+	middleware.RouteKey(http.MethodGet, "/api/v1/admin/health"):                   {Roles: []string{middleware.RoleAdmin}},
 }
 
 // NewController creates a new controller
-func NewController(service service.Service, router *gin.Engine) *Controller {
+func NewController(service service.Service, router *gin.Engine, cfg *config.Config) *Controller {
 	log.Println("Controller: Creating new controller")
 	return &Controller{
 		service: service,
 		router:  router,
+		batch:   cfg.Batch,
 	}
 }
 
-// RegisterRoutes registers all routes to the router
-func (c *Controller) RegisterRoutes(authMiddleware gin.HandlerFunc) {
+// RegisterRoutes registers all routes to the router, it fails when a proxy route collides with another route
+func (c *Controller) RegisterRoutes(authMiddleware gin.HandlerFunc, rateLimiter *middleware.RateLimiter, proxyRoutes []config.ProxyRoute) error {
 	log.Println("Controller: Registering routes...")
 
+	// Role checks for the authenticated routes
+	policyMiddleware := middleware.PolicyMiddleware(routePolicies)
+
+	// Rate limits per client IP for anonymous routes and per user for authenticated ones
+	ipRateLimit := middleware.RateLimitByIP(rateLimiter)
+	userRateLimit := middleware.RateLimitByUser(rateLimiter)
+
 	// Auth routes
 	authGroup := c.router.Group("/auth")
+	authGroup.Use(ipRateLimit)
 	{
 		authGroup.POST("/register", c.registerUser)
 		authGroup.POST("/login", c.loginUser)
+		authGroup.POST("/refresh", c.refreshToken)
+		authGroup.POST("/logout", authMiddleware, c.logoutUser)
 	}
-	log.Println("Controller: Auth routes registered: POST /auth/register, POST /auth/login")
+	log.Println("Controller: Auth routes registered: POST /auth/register, POST /auth/login, POST /auth/refresh, POST /auth/logout")
 
 	// ML routes
 	mlGroup := c.router.Group("/api/v1")
-	mlGroup.Use(authMiddleware)
+	mlGroup.Use(authMiddleware, userRateLimit, policyMiddleware)
 	{
 		mlGroup.POST("/predict", c.predict)
 		mlGroup.POST("/predict/minimal", c.predictMinimal)
-		mlGroup.POST("/train", c.trainModels)
+		mlGroup.POST("/predict/batch", c.predictBatch)
+		mlGroup.POST("/predict/csv", c.predictCSV)
+		mlGroup.POST("/train", c.startTrainingJob)
+		mlGroup.GET("/train", c.listTrainingJobs)
+		mlGroup.GET("/train/:id", c.getTrainingJob)
 		mlGroup.GET("/status", c.getModelStatus)
 	}
-	log.Println("Controller: ML routes registered with auth middleware: POST /api/v1/predict, POST /api/v1/predict/minimal, POST /api/v1/train, GET /api/v1/status")
+	log.Println("Controller: ML routes registered with auth middleware: POST /api/v1/predict, POST /api/v1/predict/minimal, POST /api/v1/predict/batch, POST /api/v1/predict/csv, POST /api/v1/train (admin), GET /api/v1/train (admin), GET /api/v1/train/:id (admin), GET /api/v1/status")
 
 	// Statistics routes
 	statsGroup := c.router.Group("/api/v1/statistics")
-	statsGroup.Use(authMiddleware)
+	statsGroup.Use(authMiddleware, userRateLimit, policyMiddleware)
 	{
 		statsGroup.GET("/user", c.getUserStatistics)
 	}
 	log.Println("Controller: Statistics routes registered with auth middleware: GET /api/v1/statistics/user")
+
+	// API key routes
+	apiKeyGroup := c.router.Group("/api/v1/api-keys")
+	apiKeyGroup.Use(authMiddleware, userRateLimit, policyMiddleware)
+	{
+		apiKeyGroup.POST("", c.createAPIKey)
+		apiKeyGroup.GET("", c.listAPIKeys)
+		apiKeyGroup.DELETE("/:id", c.revokeAPIKey)
+	}
+	log.Println("Controller: API key routes registered with auth middleware: POST /api/v1/api-keys, GET /api/v1/api-keys, DELETE /api/v1/api-keys/:id")
+
+	// Admin routes
+	adminGroup := c.router.Group("/api/v1/admin")
+	adminGroup.Use(authMiddleware, userRateLimit, policyMiddleware)
+	{
+		adminGroup.POST("/users/:id/revoke-tokens", c.revokeUserTokens)
+		adminGroup.GET("/login-lockouts", c.getLoginLockouts)
+		adminGroup.DELETE("/login-lockouts", c.clearLoginLockout)
+		adminGroup.GET("/health", c.getHealthDetails)
+	}
+	log.Println("Controller: Admin routes registered with auth middleware: POST /api/v1/admin/users/:id/revoke-tokens, GET /api/v1/admin/login-lockouts, DELETE /api/v1/admin/login-lockouts, GET /api/v1/admin/health")
+
+	// Health and metrics routes
+	c.router.GET("/health", c.getHealth)
+	c.router.GET("/health/live", c.getLiveness)
+	c.router.GET("/health/ready", c.getReadiness)
+	c.router.GET("/metrics", c.getMetrics)
+	log.Println("Controller: Health routes registered: GET /health, GET /health/live, GET /health/ready, GET /metrics")
+
+	// Configured routes forwarded by the generic reverse proxy, checked against every typed route above
+	if err := c.registerProxyRoutes(proxyRoutes, authMiddleware, ipRateLimit, userRateLimit); err != nil {
+		return err
+	}
 	log.Println("Controller: All routes registered")
+	return nil
 }
 
 // registerUser handles user registration
@@ -69,10 +154,10 @@ func (c *Controller) registerUser(ctx *gin.Context) {
 	}
 
 	log.Printf("Controller: Registering user with email: %s", request.Email)
-	response, err := c.service.RegisterUser(&request)
+	response, err := c.service.RegisterUser(ctx.Request.Context(), &request)
 	if err != nil {
 		log.Printf("Controller: Error registering user: %v", err)
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -91,10 +176,17 @@ func (c *Controller) loginUser(ctx *gin.Context) {
 	}
 
 	log.Printf("Controller: Logging in user with email: %s", request.Email)
-	response, err := c.service.LoginUser(&request)
+	response, err := c.service.LoginUser(ctx.Request.Context(), &request, ctx.ClientIP())
 	if err != nil {
+		var blocked *service.LoginBlockedError
+		if errors.As(err, &blocked) {
+			log.Printf("Controller: Login blocked: %v", err)
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+			ctx.JSON(http.StatusTooManyRequests, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		log.Printf("Controller: Error logging in user: %v", err)
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -102,10 +194,69 @@ func (c *Controller) loginUser(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+// refreshToken handles access token refresh
+func (c *Controller) refreshToken(ctx *gin.Context) {
+	log.Println("Controller: Handling refreshToken request")
+	var request model.RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		log.Printf("Controller: Invalid request format: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+
+	response, err := c.service.RefreshToken(ctx.Request.Context(), &request)
+	if err != nil {
+		if errors.Is(err, service.ErrRefreshTokenRevoked) {
+			ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error(), Code: middleware.ErrCodeTokenRevoked})
+			return
+		}
+		log.Printf("Controller: Error refreshing token: %v", err)
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Println("Controller: Token refreshed successfully")
+	ctx.JSON(http.StatusOK, response)
+}
+
+// logoutUser handles user logout
+func (c *Controller) logoutUser(ctx *gin.Context) {
+	log.Println("Controller: Handling logoutUser request")
+	tokenID, expiresAt, err := middleware.GetTokenID(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var request model.LogoutRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Printf("Controller: Invalid request format: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+
+	identity, err := middleware.GetIdentity(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := c.service.LogoutUser(ctx.Request.Context(), tokenID, identity, expiresAt, &request); err != nil {
+		log.Printf("Controller: Error logging out user: %v", err)
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Println("Controller: User logged out successfully")
+	ctx.Status(http.StatusNoContent)
+}
+
 // predict handles predictions
 func (c *Controller) predict(ctx *gin.Context) {
 	log.Println("Controller: Handling predict request")
-	userID, err := middleware.GetUserID(ctx)
+	identity, err := middleware.GetIdentity(ctx)
 	if err != nil {
 		log.Printf("Controller: Unauthorized access: %v", err)
 		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
@@ -119,22 +270,29 @@ func (c *Controller) predict(ctx *gin.Context) {
 		return
 	}
 
-	log.Printf("Controller: Making prediction for product: %s by user: %s", request.ProductName, userID)
-	result, err := c.service.Predict(userID, &request)
+	log.Printf("Controller: Making prediction for product: %s by user: %s", request.ProductName, identity.UserID)
+	result, err := c.service.Predict(ctx.Request.Context(), identity, &request, noCache(ctx))
 	if err != nil {
+		var validationErr *service.ValidationError
+		if errors.As(err, &validationErr) {
+			log.Printf("Controller: Invalid prediction request: %v", err)
+			ctx.JSON(http.StatusUnprocessableEntity, model.ValidationErrorResponse{Error: "Validation failed", Errors: validationErr.Errors})
+			return
+		}
 		log.Printf("Controller: Error making prediction: %v", err)
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	log.Printf("Controller: Prediction successful, price: %f, sales: %f", result.PredictedPrice, result.PredictedSales)
+	setCacheStatus(ctx, result)
 	ctx.JSON(http.StatusOK, result)
 }
 
 // predictMinimal handles minimal predictions
 func (c *Controller) predictMinimal(ctx *gin.Context) {
 	log.Println("Controller: Handling predictMinimal request")
-	userID, err := middleware.GetUserID(ctx)
+	identity, err := middleware.GetIdentity(ctx)
 	if err != nil {
 		log.Printf("Controller: Unauthorized access: %v", err)
 		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
@@ -148,39 +306,148 @@ func (c *Controller) predictMinimal(ctx *gin.Context) {
 		return
 	}
 
-	log.Printf("Controller: Making minimal prediction for product: %s by user: %s", request.ProductName, userID)
-	result, err := c.service.PredictMinimal(userID, &request)
+	log.Printf("Controller: Making minimal prediction for product: %s by user: %s", request.ProductName, identity.UserID)
+	result, err := c.service.PredictMinimal(ctx.Request.Context(), identity, &request, noCache(ctx))
 	if err != nil {
+		var validationErr *service.ValidationError
+		if errors.As(err, &validationErr) {
+			log.Printf("Controller: Invalid prediction request: %v", err)
+			ctx.JSON(http.StatusUnprocessableEntity, model.ValidationErrorResponse{Error: "Validation failed", Errors: validationErr.Errors})
+			return
+		}
 		log.Printf("Controller: Error making minimal prediction: %v", err)
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	log.Printf("Controller: Minimal prediction successful, price: %f, sales: %f", result.PredictedPrice, result.PredictedSales)
+	setCacheStatus(ctx, result)
 	ctx.JSON(http.StatusOK, result)
 }
 
-// trainModels handles model training
-func (c *Controller) trainModels(ctx *gin.Context) {
-	log.Println("Controller: Handling trainModels request")
-	result, err := c.service.TrainModels()
+// predictBatch handles batch predictions
+func (c *Controller) predictBatch(ctx *gin.Context) {
+	log.Println("Controller: Handling predictBatch request")
+	identity, err := middleware.GetIdentity(ctx)
 	if err != nil {
-		log.Printf("Controller: Error training models: %v", err)
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	log.Println("Controller: Models trained successfully")
-	ctx.JSON(http.StatusOK, result)
+	var request model.BatchPredictionRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Printf("Controller: Invalid request format: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+
+	response, err := c.service.PredictBatch(ctx.Request.Context(), identity, &request, noCache(ctx))
+	if err != nil {
+		log.Printf("Controller: Error making batch prediction: %v", err)
+		if errors.Is(err, service.ErrInvalidBatch) {
+			ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	setBatchItemErrors(response)
+	// The request counts against the rate limit once per item
+	middleware.ChargeRateLimit(ctx, len(response.Results))
+
+	log.Printf("Controller: Batch prediction done, succeeded: %d, failed: %d", response.Succeeded, response.Failed)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// startTrainingJob handles starting a model training job
+func (c *Controller) startTrainingJob(ctx *gin.Context) {
+	log.Println("Controller: Handling startTrainingJob request")
+	identity, err := middleware.GetIdentity(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	job, err := c.service.StartTrainingJob(ctx.Request.Context(), identity)
+	if err != nil {
+		if errors.Is(err, service.ErrTrainingInProgress) {
+			ctx.JSON(http.StatusConflict, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		log.Printf("Controller: Error starting training job: %v", err)
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: Training job started: %s", job.ID)
+	ctx.Header("Location", "/api/v1/train/"+job.ID.String())
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// getTrainingJob handles getting a training job
+func (c *Controller) getTrainingJob(ctx *gin.Context) {
+	log.Println("Controller: Handling getTrainingJob request")
+	jobID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		log.Printf("Controller: Invalid training job ID: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid training job ID"})
+		return
+	}
+
+	job, err := c.service.GetTrainingJob(ctx.Request.Context(), jobID)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Training job not found"})
+			return
+		}
+		log.Printf("Controller: Error getting training job: %v", err)
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: Training job %s is %s", job.ID, job.State)
+	ctx.JSON(http.StatusOK, job)
+}
+
+// listTrainingJobs handles listing the most recent training jobs
+func (c *Controller) listTrainingJobs(ctx *gin.Context) {
+	log.Println("Controller: Handling listTrainingJobs request")
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultTrainingJobsLimit)))
+	if err != nil || limit < 1 {
+		log.Printf("Controller: Invalid limit: %q", ctx.Query("limit"))
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid limit"})
+		return
+	}
+	limit = min(limit, maxTrainingJobsLimit)
+
+	jobs, err := c.service.ListTrainingJobs(ctx.Request.Context(), limit)
+	if err != nil {
+		log.Printf("Controller: Error listing training jobs: %v", err)
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: Training jobs retrieved, count: %d", len(jobs))
+	ctx.JSON(http.StatusOK, jobs)
 }
 
 // getModelStatus handles getting model status
 func (c *Controller) getModelStatus(ctx *gin.Context) {
 	log.Println("Controller: Handling getModelStatus request")
-	status, err := c.service.GetModelStatus()
+	identity, err := middleware.GetIdentity(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	status, err := c.service.GetModelStatus(ctx.Request.Context(), identity)
 	if err != nil {
 		log.Printf("Controller: Error getting model status: %v", err)
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -199,7 +466,7 @@ func (c *Controller) getUserStatistics(ctx *gin.Context) {
 	}
 
 	log.Printf("Controller: Getting statistics for user: %s", userID)
-	statistics, err := c.service.GetUserStatistics(userID)
+	statistics, err := c.service.GetUserStatistics(ctx.Request.Context(), userID)
 	if err != nil {
 		log.Printf("Controller: Error getting user statistics: %v", err)
 		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
@@ -209,3 +476,284 @@ func (c *Controller) getUserStatistics(ctx *gin.Context) {
 	log.Printf("Controller: Statistics retrieved, prediction count: %d", len(statistics.Predictions))
 	ctx.JSON(http.StatusOK, statistics)
 }
+
+// createAPIKey handles API key creation
+func (c *Controller) createAPIKey(ctx *gin.Context) {
+	log.Println("Controller: Handling createAPIKey request")
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var request model.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Name == "" || len(request.Scopes) == 0 {
+		log.Printf("Controller: Invalid request format: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !slices.Contains(model.APIKeyScopes, scope) {
+			log.Printf("Controller: Unknown API key scope: %s", scope)
+			ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Unknown scope: " + scope})
+			return
+		}
+	}
+
+	response, err := c.service.CreateAPIKey(ctx.Request.Context(), userID, &request)
+	if err != nil {
+		log.Printf("Controller: Error creating API key: %v", err)
+		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: API key created with ID: %s", response.ID)
+	ctx.JSON(http.StatusCreated, response)
+}
+
+// listAPIKeys handles listing the API keys of the user
+func (c *Controller) listAPIKeys(ctx *gin.Context) {
+	log.Println("Controller: Handling listAPIKeys request")
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	apiKeys, err := c.service.ListAPIKeys(ctx.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Controller: Error listing API keys: %v", err)
+		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: API keys retrieved, count: %d", len(apiKeys))
+	ctx.JSON(http.StatusOK, apiKeys)
+}
+
+// revokeAPIKey handles API key revocation
+func (c *Controller) revokeAPIKey(ctx *gin.Context) {
+	log.Println("Controller: Handling revokeAPIKey request")
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	keyID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		log.Printf("Controller: Invalid API key ID: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid API key ID"})
+		return
+	}
+
+	if err := c.service.RevokeAPIKey(ctx.Request.Context(), userID, keyID); err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, model.ErrorResponse{Error: "API key not found"})
+			return
+		}
+		log.Printf("Controller: Error revoking API key: %v", err)
+		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: API key revoked: %s", keyID)
+	ctx.Status(http.StatusNoContent)
+}
+
+// revokeUserTokens handles revoking all tokens of a user
+func (c *Controller) revokeUserTokens(ctx *gin.Context) {
+	log.Println("Controller: Handling revokeUserTokens request")
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		log.Printf("Controller: Invalid user ID: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	revocation, err := c.service.RevokeUserTokens(ctx.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Controller: Error revoking user tokens: %v", err)
+		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: Tokens revoked for user: %s", userID)
+	ctx.JSON(http.StatusOK, revocation)
+}
+
+// getLoginLockouts handles listing the emails and IPs with failed logins
+func (c *Controller) getLoginLockouts(ctx *gin.Context) {
+	log.Println("Controller: Handling getLoginLockouts request")
+	lockouts := c.service.GetLoginLockouts(ctx.Request.Context())
+
+	log.Printf("Controller: Login lockouts retrieved, count: %d", len(lockouts))
+	ctx.JSON(http.StatusOK, lockouts)
+}
+
+// clearLoginLockout handles clearing the failed logins of an email or an IP
+func (c *Controller) clearLoginLockout(ctx *gin.Context) {
+	log.Println("Controller: Handling clearLoginLockout request")
+	email := ctx.Query("email")
+	ip := ctx.Query("ip")
+	if email == "" && ip == "" {
+		log.Println("Controller: Neither email nor IP given")
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "email or ip query parameter is required"})
+		return
+	}
+
+	if !c.service.ClearLoginLockout(ctx.Request.Context(), email, ip) {
+		ctx.JSON(http.StatusNotFound, model.ErrorResponse{Error: "No failed logins found"})
+		return
+	}
+
+	log.Printf("Controller: Login lockout cleared for email: %q, IP: %q", email, ip)
+	ctx.Status(http.StatusNoContent)
+}
+
+// getHealth handles the public health report of the dependencies and upstream services. It always
+// responds with 200, the status is degraded while a dependency is not up or a circuit is not closed.
+// Instance addresses and probe errors are left out, admins find them in the detailed report.
+func (c *Controller) getHealth(ctx *gin.Context) {
+	response := c.healthReport(ctx)
+	for i := range response.Dependencies {
+		response.Dependencies[i].LastError = ""
+	}
+	for i := range response.Upstreams {
+		response.Upstreams[i].Instances = nil
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// getHealthDetails handles the detailed health report, including the upstream instances and probe errors
+func (c *Controller) getHealthDetails(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.healthReport(ctx))
+}
+
+// healthReport returns the health of the dependencies and upstream services
+func (c *Controller) healthReport(ctx *gin.Context) model.HealthResponse {
+	response := model.HealthResponse{
+		Status:       "ok",
+		Dependencies: c.service.GetDependencyStatus(ctx.Request.Context()),
+		Upstreams:    c.service.GetUpstreamStatus(ctx.Request.Context()),
+	}
+	if !dependenciesUp(response.Dependencies) {
+		response.Status = "degraded"
+	}
+	for _, upstream := range response.Upstreams {
+		if upstream.CircuitBreaker.State != model.CircuitClosed {
+			response.Status = "degraded"
+		}
+	}
+	return response
+}
+
+// getLiveness handles the liveness check, which only tells that the gateway serves requests
+func (c *Controller) getLiveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, model.HealthResponse{Status: "ok"})
+}
+
+// getReadiness handles the readiness check, responding with 503 until every required dependency is up.
+// Upstream services are left out: while one is down its routes fail, but the gateway can serve the others.
+func (c *Controller) getReadiness(ctx *gin.Context) {
+	var dependencies []model.DependencyStatus
+	for _, dependency := range c.service.GetDependencyStatus(ctx.Request.Context()) {
+		if dependency.Required {
+			dependency.LastError = ""
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	if !dependenciesUp(dependencies) {
+		ctx.JSON(http.StatusServiceUnavailable, model.HealthResponse{Status: "not_ready", Dependencies: dependencies})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, model.HealthResponse{Status: "ready", Dependencies: dependencies})
+}
+
+// dependenciesUp reports whether the latest probe of every dependency succeeded
+func dependenciesUp(dependencies []model.DependencyStatus) bool {
+	for _, dependency := range dependencies {
+		if dependency.Status != model.DependencyUp {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceErrorStatus returns the status code for an error of a call to an upstream service,
+// setting Retry-After when the service is temporarily unavailable. Calls that ran out of their
+// retry budget are reported as gateway timeouts, calls abandoned by the client with 499.
+func serviceErrorStatus(ctx *gin.Context, err error) int {
+	var circuitOpen *service.CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(circuitOpen.RetryAfter.Seconds()))))
+	}
+	var bulkheadFull *service.BulkheadFullError
+	if errors.As(err, &bulkheadFull) {
+		// Slots free up as soon as calls in flight finish
+		ctx.Header("Retry-After", "1")
+	}
+	return errorStatus(err)
+}
+
+// noCache reports whether the client asked to bypass the prediction cache with Cache-Control: no-cache
+func noCache(ctx *gin.Context) bool {
+	for _, value := range ctx.Request.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setCacheStatus reports whether a prediction came from the cache in the X-Cache header
+func setCacheStatus(ctx *gin.Context, result *model.PredictionResult) {
+	if result.CacheStatus != "" {
+		ctx.Header("X-Cache", result.CacheStatus)
+	}
+}
+
+// setBatchItemErrors fills the status and the message of the failed items of a batch
+func setBatchItemErrors(response *model.BatchPredictionResponse) {
+	for i := range response.Results {
+		item := &response.Results[i]
+		if item.Err == nil {
+			continue
+		}
+		item.Error = item.Err.Error()
+		var validationErr *service.ValidationError
+		switch {
+		case errors.Is(item.Err, service.ErrInvalidBatchItem):
+			item.Status = http.StatusBadRequest
+		case errors.As(item.Err, &validationErr):
+			item.Status = http.StatusUnprocessableEntity
+			item.Errors = validationErr.Errors
+		default:
+			item.Status = errorStatus(item.Err)
+		}
+	}
+}
+
+// errorStatus returns the status code for an error of a call to an upstream service
+func errorStatus(err error) int {
+	var circuitOpen *service.CircuitOpenError
+	var bulkheadFull *service.BulkheadFullError
+	switch {
+	case errors.As(err, &circuitOpen), errors.As(err, &bulkheadFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/service"
+)
+
+// Columns added to the uploaded CSV in the response
+const (
+	csvColumnPredictedPrice = "predicted_price"
+	csvColumnPredictedSales = "predicted_sales"
+	csvColumnError          = "error"
+)
+
+// csvRequiredColumns must be present in the header of an uploaded CSV
+var csvRequiredColumns = []string{"product_name", "brand", "category", "region", "seller"}
+
+// csvFields maps the JSON tags of PredictionRequest, which are the accepted CSV columns, to their field index
+var csvFields = func() map[string]int {
+	fields := map[string]int{}
+	requestType := reflect.TypeFor[model.PredictionRequest]()
+	for i := range requestType.NumField() {
+		name, _, _ := strings.Cut(requestType.Field(i).Tag.Get("json"), ",")
+		fields[name] = i
+	}
+	return fields
+}()
+
+// predictCSV handles bulk predictions from an uploaded CSV file, whose columns are PredictionRequest fields.
+// The response is the same CSV with the predictions, and the error of the rows that failed, added.
+func (c *Controller) predictCSV(ctx *gin.Context) {
+	log.Println("Controller: Handling predictCSV request")
+	identity, err := middleware.GetIdentity(ctx)
+	if err != nil {
+		log.Printf("Controller: Unauthorized access: %v", err)
+		ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, c.batch.MaxUploadBytes)
+	fileHeader, err := ctx.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		log.Printf("Controller: CSV upload too large: %v", err)
+		ctx.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: fmt.Sprintf("The upload is larger than %d bytes", maxBytesErr.Limit)})
+		return
+	}
+	if err != nil {
+		log.Printf("Controller: Missing CSV file: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "A CSV file is required in the file form field"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Controller: Error opening CSV file: %v", err)
+		ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid CSV file"})
+		return
+	}
+	defer file.Close()
+
+	header, rows, requests, lineErrors := parsePredictionCSV(file, c.batch.MaxItems)
+	if len(lineErrors) > 0 {
+		log.Printf("Controller: Invalid CSV file, %d errors", len(lineErrors))
+		// A readable header means the file is well formed and only the values of its rows are invalid,
+		// the header is dropped for files that are not, like those with too many rows
+		status := http.StatusUnprocessableEntity
+		if header == nil {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, model.CSVErrorResponse{Error: "Invalid CSV file", Errors: lineErrors})
+		return
+	}
+
+	response, err := c.service.PredictRequests(ctx.Request.Context(), identity, requests, noCache(ctx))
+	if err != nil {
+		log.Printf("Controller: Error making CSV prediction: %v", err)
+		if errors.Is(err, service.ErrInvalidBatch) {
+			ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		ctx.JSON(serviceErrorStatus(ctx, err), model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	setBatchItemErrors(response)
+	// The request counts against the rate limit once per row
+	middleware.ChargeRateLimit(ctx, len(response.Results))
+
+	var output bytes.Buffer
+	writer := csv.NewWriter(&output)
+	writer.Write(append(header, csvColumnPredictedPrice, csvColumnPredictedSales, csvColumnError))
+	for i, item := range response.Results {
+		record := append([]string{}, rows[i]...)
+		if item.Result != nil {
+			record = append(record,
+				strconv.FormatFloat(item.Result.PredictedPrice, 'f', -1, 64),
+				strconv.FormatFloat(item.Result.PredictedSales, 'f', -1, 64),
+				"")
+		} else {
+			record = append(record, "", "", item.Error)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Controller: Error writing CSV: %v", err)
+		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Controller: CSV prediction done, succeeded: %d, failed: %d", response.Succeeded, response.Failed)
+	ctx.Header("Content-Disposition", `attachment; filename="predictions.csv"`)
+	ctx.Data(http.StatusOK, "text/csv; charset=utf-8", output.Bytes())
+}
+
+// parsePredictionCSV reads the header and the rows of a CSV file and decodes and validates every row into
+// a PredictionRequest. All invalid columns and values are reported with their line number, the header
+// is nil when the file has no valid header. Reading stops at the first row beyond maxRows, which fails
+// the whole file like an invalid header.
+func parsePredictionCSV(file io.Reader, maxRows int) ([]string, [][]string, []model.PredictionRequest, []model.LineError) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, nil, []model.LineError{csvError(1, "", "the file is empty, a header row is required")}
+		}
+		return nil, nil, nil, []model.LineError{csvLineError(err, 1)}
+	}
+
+	var lineErrors []model.LineError
+	columns := make([]int, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		// Spreadsheet exports may start with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		header[i] = name
+		index, known := csvFields[name]
+		switch {
+		case !known:
+			lineErrors = append(lineErrors, csvError(1, name, "unknown column"))
+		case seen[name]:
+			lineErrors = append(lineErrors, csvError(1, name, "duplicate column"))
+		}
+		seen[name] = true
+		columns[i] = index
+	}
+	for _, name := range csvRequiredColumns {
+		if !seen[name] {
+			lineErrors = append(lineErrors, csvError(1, name, "missing required column"))
+		}
+	}
+	if len(lineErrors) > 0 {
+		return nil, nil, nil, lineErrors
+	}
+
+	var rows [][]string
+	var requests []model.PredictionRequest
+	for count := 1; ; count++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if count > maxRows {
+			line, _ := reader.FieldPos(0)
+			return nil, nil, nil, []model.LineError{csvError(line, "", fmt.Sprintf("the file has more than %d rows", maxRows))}
+		}
+		if err != nil {
+			lineErrors = append(lineErrors, csvLineError(err, 0))
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+				continue
+			}
+			// The rest of the file cannot be read reliably after a syntax error
+			break
+		}
+		line, _ := reader.FieldPos(0)
+
+		var request model.PredictionRequest
+		value := reflect.ValueOf(&request).Elem()
+		parsed := true
+		for i, cell := range record {
+			if err := setCSVField(value.Field(columns[i]), strings.TrimSpace(cell)); err != nil {
+				lineErrors = append(lineErrors, csvError(line, header[i], err.Error()))
+				parsed = false
+			}
+		}
+		// Only rows whose values all parsed are validated, a zero value left by a bad cell would be misreported
+		if parsed {
+			for _, fieldError := range request.Validate() {
+				lineErrors = append(lineErrors, model.LineError{Line: line, FieldError: fieldError})
+			}
+		}
+		rows = append(rows, record)
+		requests = append(requests, request)
+	}
+	return header, rows, requests, lineErrors
+}
+
+// setCSVField parses a CSV cell into a PredictionRequest field, an empty cell leaves the zero value
+func setCSVField(field reflect.Value, cell string) error {
+	if cell == "" {
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(cell)
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", cell)
+		}
+		field.SetFloat(value)
+	case reflect.Int:
+		value, err := strconv.Atoi(cell)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", cell)
+		}
+		field.SetInt(int64(value))
+	case reflect.Bool:
+		value, err := strconv.ParseBool(cell)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", cell)
+		}
+		field.SetBool(value)
+	default:
+		return fmt.Errorf("unsupported column type %s", field.Kind())
+	}
+	return nil
+}
+
+// csvError creates the error of a column, or of the whole line when field is empty, at a line of a CSV file
+func csvError(line int, field, message string) model.LineError {
+	return model.LineError{Line: line, FieldError: model.FieldError{Field: field, Message: message}}
+}
+
+// csvLineError converts a CSV reading error into a line error
+func csvLineError(err error, line int) model.LineError {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return csvError(parseErr.Line, "", parseErr.Err.Error())
+	}
+	return csvError(line, "", err.Error())
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+)
+
+func TestPredictCSVBoundsTheUpload(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantStatus int
+	}{
+		{
+			name:       "too large",
+			content:    "product_name,brand,category,region,seller,month,quarter\n" + strings.Repeat("P,B,C,R,S,1,1\n", testMaxUploadBytes/14+1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "too many rows",
+			content:    "product_name,brand,category,region,seller,month,quarter\n" + strings.Repeat("P,B,C,R,S,1,1\n", testBatchMaxItems+1),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too many malformed rows",
+			content:    "product_name,brand,category,region,seller,month,quarter\n" + strings.Repeat("P,B\n", testBatchMaxItems+1),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upload bytes.Buffer
+			writer := multipart.NewWriter(&upload)
+			file, err := writer.CreateFormFile("file", "products.csv")
+			if err != nil {
+				t.Fatalf("create form file: %v", err)
+			}
+			file.Write([]byte(tt.content))
+			writer.Close()
+
+			router := newTestRouter(t, &fakeService{})
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/predict/csv", &upload)
+			request.Header.Set("Content-Type", writer.FormDataContentType())
+			request.Header.Set("Authorization", "Bearer "+signTestToken(t, middleware.RoleUser))
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// healthService reports fixed dependency and upstream states
+type healthService struct {
+	fakeService
+	dependencies []model.DependencyStatus
+}
+
+func (h *healthService) GetDependencyStatus(context.Context) []model.DependencyStatus {
+	return append([]model.DependencyStatus(nil), h.dependencies...)
+}
+
+func (h *healthService) GetUpstreamStatus(context.Context) []model.UpstreamStatus {
+	return []model.UpstreamStatus{{
+		Name:           "ML",
+		CircuitBreaker: model.CircuitBreakerStatus{State: model.CircuitClosed},
+		Instances:      []model.InstanceStatus{{Address: "ml-1.internal:8000"}},
+	}}
+}
+
+// getHealthResponse makes a GET request and decodes the health response
+func getHealthResponse(t *testing.T, svc *healthService, path, token string) (int, model.HealthResponse, string) {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	newTestRouter(t, svc).ServeHTTP(recorder, request)
+
+	var response model.HealthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v, body: %s", err, recorder.Body.String())
+	}
+	return recorder.Code, response, recorder.Body.String()
+}
+
+func TestReadinessOnlyDependsOnRequiredDependencies(t *testing.T) {
+	upstreamDown := &healthService{dependencies: []model.DependencyStatus{
+		{Name: "postgres", Required: true, Status: model.DependencyUp},
+		{Name: "auth", Status: model.DependencyDown, LastError: "auth-1.internal:8080: connection refused"},
+		{Name: "ml", Status: model.DependencyUp},
+	}}
+	code, response, body := getHealthResponse(t, upstreamDown, "/health/ready", "")
+	if code != http.StatusOK || response.Status != "ready" {
+		t.Errorf("status = %d %q, want %d ready", code, response.Status, http.StatusOK)
+	}
+	if len(response.Dependencies) != 1 || response.Dependencies[0].Name != "postgres" {
+		t.Errorf("dependencies = %+v, want postgres only", response.Dependencies)
+	}
+	if strings.Contains(body, "internal") {
+		t.Errorf("readiness exposes upstream details: %s", body)
+	}
+
+	databaseDown := &healthService{dependencies: []model.DependencyStatus{
+		{Name: "postgres", Required: true, Status: model.DependencyDown, LastError: "dial tcp 10.0.0.5:5432: connection refused"},
+		{Name: "auth", Status: model.DependencyUp},
+		{Name: "ml", Status: model.DependencyUp},
+	}}
+	code, response, body = getHealthResponse(t, databaseDown, "/health/ready", "")
+	if code != http.StatusServiceUnavailable || response.Status != "not_ready" {
+		t.Errorf("status = %d %q, want %d not_ready", code, response.Status, http.StatusServiceUnavailable)
+	}
+	if strings.Contains(body, "10.0.0.5") {
+		t.Errorf("readiness exposes the probe error: %s", body)
+	}
+}
+
+func TestHealthHidesDetailsFromThePublic(t *testing.T) {
+	svc := &healthService{dependencies: []model.DependencyStatus{
+		{Name: "postgres", Required: true, Status: model.DependencyUp},
+		{Name: "ml", Status: model.DependencyDown, LastError: "ml-1.internal:8000: connection refused"},
+	}}
+
+	code, response, body := getHealthResponse(t, svc, "/health", "")
+	if code != http.StatusOK || response.Status != "degraded" {
+		t.Errorf("status = %d %q, want %d degraded", code, response.Status, http.StatusOK)
+	}
+	if strings.Contains(body, "ml-1.internal") {
+		t.Errorf("public health report exposes instance details: %s", body)
+	}
+
+	code, response, body = getHealthResponse(t, svc, "/api/v1/admin/health", signTestToken(t, middleware.RoleAdmin))
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	if response.Dependencies[1].LastError == "" || len(response.Upstreams[0].Instances) != 1 {
+		t.Errorf("detailed health report misses instance details: %s", body)
+	}
+}
+
+func TestMetricsHideInstanceAddresses(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	newTestRouter(t, &healthService{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "ml-1.internal") {
+		t.Errorf("metrics expose instance addresses: %s", body)
+	}
+	if !strings.Contains(body, `gateway_upstream_instance_outstanding{upstream="ml",instance="0"} 0`) {
+		t.Errorf("metrics miss the instance gauge: %s", body)
+	}
+}
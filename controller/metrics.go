@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// circuitStateValues are the values of the circuit breaker state gauge
+var circuitStateValues = map[string]int{
+	model.CircuitClosed:   0,
+	model.CircuitHalfOpen: 1,
+	model.CircuitOpen:     2,
+}
+
+// getMetrics handles the metrics endpoint in the Prometheus text format. Upstream instances are
+// labelled with their position in the configured endpoints rather than their address, the detailed
+// health report of the admin API maps them back.
+func (c *Controller) getMetrics(ctx *gin.Context) {
+	upstreams := c.service.GetUpstreamStatus(ctx.Request.Context())
+
+	var metrics strings.Builder
+	writeMetric(&metrics, "gateway_dependency_up", "gauge",
+		"Whether the latest probe of a dependency succeeded: 1 up, 0 down or not probed yet")
+	for _, dependency := range c.service.GetDependencyStatus(ctx.Request.Context()) {
+		up := 0
+		if dependency.Status == model.DependencyUp {
+			up = 1
+		}
+		fmt.Fprintf(&metrics, "gateway_dependency_up{dependency=%q} %d\n", dependency.Name, up)
+	}
+
+	writeMetric(&metrics, "gateway_circuit_breaker_state", "gauge",
+		"Circuit breaker state per upstream service: 0 closed, 1 half-open, 2 open")
+	for _, upstream := range upstreams {
+		fmt.Fprintf(&metrics, "gateway_circuit_breaker_state{upstream=%q} %d\n",
+			upstreamLabel(upstream), circuitStateValues[upstream.CircuitBreaker.State])
+	}
+
+	writeMetric(&metrics, "gateway_circuit_breaker_calls_total", "counter",
+		"Calls to upstream services by circuit breaker outcome")
+	for _, upstream := range upstreams {
+		breaker := upstream.CircuitBreaker
+		for _, outcome := range []struct {
+			result string
+			value  uint64
+		}{
+			{"success", breaker.SuccessesTotal},
+			{"failure", breaker.FailuresTotal},
+			{"rejected", breaker.RejectedTotal},
+		} {
+			fmt.Fprintf(&metrics, "gateway_circuit_breaker_calls_total{upstream=%q,result=%q} %d\n",
+				upstreamLabel(upstream), outcome.result, outcome.value)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_circuit_breaker_opened_total", "counter",
+		"Times the circuit breaker of an upstream service opened")
+	for _, upstream := range upstreams {
+		fmt.Fprintf(&metrics, "gateway_circuit_breaker_opened_total{upstream=%q} %d\n",
+			upstreamLabel(upstream), upstream.CircuitBreaker.OpenedTotal)
+	}
+
+	writeMetric(&metrics, "gateway_bulkhead_active", "gauge",
+		"Calls in flight holding a bulkhead slot per upstream service")
+	for _, upstream := range upstreams {
+		if upstream.Bulkhead != nil {
+			fmt.Fprintf(&metrics, "gateway_bulkhead_active{upstream=%q} %d\n", upstreamLabel(upstream), upstream.Bulkhead.Active)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_bulkhead_queued", "gauge",
+		"Calls waiting for a free bulkhead slot per upstream service")
+	for _, upstream := range upstreams {
+		if upstream.Bulkhead != nil {
+			fmt.Fprintf(&metrics, "gateway_bulkhead_queued{upstream=%q} %d\n", upstreamLabel(upstream), upstream.Bulkhead.Queued)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_bulkhead_rejected_total", "counter",
+		"Calls rejected with 503 because the bulkhead of an upstream service was full")
+	for _, upstream := range upstreams {
+		if upstream.Bulkhead != nil {
+			fmt.Fprintf(&metrics, "gateway_bulkhead_rejected_total{upstream=%q} %d\n", upstreamLabel(upstream), upstream.Bulkhead.RejectedTotal)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_hedged_requests_total", "counter",
+		"Extra attempts sent by hedged calls per upstream service")
+	for _, upstream := range upstreams {
+		if upstream.Hedging != nil {
+			fmt.Fprintf(&metrics, "gateway_hedged_requests_total{upstream=%q} %d\n", upstreamLabel(upstream), upstream.Hedging.HedgesTotal)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_hedge_wins_total", "counter",
+		"Hedged calls answered by an extra attempt before the first one per upstream service")
+	for _, upstream := range upstreams {
+		if upstream.Hedging != nil {
+			fmt.Fprintf(&metrics, "gateway_hedge_wins_total{upstream=%q} %d\n", upstreamLabel(upstream), upstream.Hedging.WinsTotal)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_upstream_instance_outstanding", "gauge",
+		"Calls in flight per upstream service instance")
+	for _, upstream := range upstreams {
+		for i, inst := range upstream.Instances {
+			fmt.Fprintf(&metrics, "gateway_upstream_instance_outstanding{upstream=%q,instance=\"%d\"} %d\n",
+				upstreamLabel(upstream), i, inst.Outstanding)
+		}
+	}
+
+	writeMetric(&metrics, "gateway_upstream_instance_ejected", "gauge",
+		"Whether an upstream service instance is ejected after consecutive failures: 1 ejected, 0 serving")
+	for _, upstream := range upstreams {
+		for i, inst := range upstream.Instances {
+			ejected := 0
+			if inst.EjectedUntil != nil {
+				ejected = 1
+			}
+			fmt.Fprintf(&metrics, "gateway_upstream_instance_ejected{upstream=%q,instance=\"%d\"} %d\n",
+				upstreamLabel(upstream), i, ejected)
+		}
+	}
+
+	ctx.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(metrics.String()))
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric
+func writeMetric(metrics *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(metrics, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(metrics, "# TYPE %s %s\n", name, metricType)
+}
+
+// upstreamLabel returns the label value of an upstream service
+func upstreamLabel(upstream model.UpstreamStatus) string {
+	return strings.ToLower(upstream.Name)
+}
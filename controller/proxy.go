@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// registerProxyRoutes registers the configured routes that are forwarded to an upstream service as they are.
+// It fails on a route colliding with one already registered, a typed or an earlier proxy route, since the
+// router would panic on it or serve it with the handler of the other route.
+func (c *Controller) registerProxyRoutes(routes []config.ProxyRoute, authMiddleware, ipRateLimit, userRateLimit gin.HandlerFunc) error {
+	// Role and scope checks of the authenticated proxy routes
+	policies := middleware.PolicyTable{}
+	for _, route := range routes {
+		if route.AuthRequired {
+			policies[middleware.RouteKey(route.Method, route.Path)] = middleware.Policy{Roles: route.Roles, Scopes: route.Scopes}
+		}
+	}
+	policyMiddleware := middleware.PolicyMiddleware(policies)
+
+	for i, route := range routes {
+		for _, registered := range c.router.Routes() {
+			if registered.Method == route.Method && routesConflict(registered.Path, route.Path) {
+				return fmt.Errorf("proxy route %d: %s %s collides with the route %s", i, route.Method, route.Path, registered.Path)
+			}
+		}
+
+		handlers := []gin.HandlerFunc{ipRateLimit}
+		if route.AuthRequired {
+			handlers = []gin.HandlerFunc{authMiddleware, userRateLimit, policyMiddleware}
+		}
+		handlers = append(handlers, c.proxy(route))
+
+		c.router.Handle(route.Method, route.Path, handlers...)
+		log.Printf("Controller: Proxy route registered: %s %s -> %s %s, auth required: %t", route.Method, route.Path, route.Upstream, cmp.Or(route.Rewrite, route.Path), route.AuthRequired)
+	}
+	return nil
+}
+
+// routesConflict reports whether two route paths of the same method cannot be registered together.
+// Like the gin router, a path segment may be both static and a :name parameter, but not parameters of
+// different names or a *name catch-all and anything else.
+func routesConflict(a, b string) bool {
+	aSegments, bSegments := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < min(len(aSegments), len(bSegments)); i++ {
+		aSegment, bSegment := aSegments[i], bSegments[i]
+		aParam, bParam := strings.HasPrefix(aSegment, ":"), strings.HasPrefix(bSegment, ":")
+		switch {
+		case strings.HasPrefix(aSegment, "*") || strings.HasPrefix(bSegment, "*"):
+			return true
+		case aParam && bParam:
+			if aSegment != bSegment {
+				return true
+			}
+		case aParam || bParam:
+			// Static segments take precedence over the parameter, the rest of the paths are apart
+			return false
+		case aSegment != bSegment:
+			return false
+		}
+	}
+	return len(aSegments) == len(bSegments)
+}
+
+// proxy creates the handler forwarding a proxy route to its upstream service
+func (c *Controller) proxy(route config.ProxyRoute) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		log.Printf("Controller: Handling proxy request: %s %s", ctx.Request.Method, ctx.Request.URL.Path)
+
+		var identity *model.Identity
+		if route.AuthRequired {
+			var err error
+			identity, err = middleware.GetIdentity(ctx)
+			if err != nil {
+				log.Printf("Controller: Unauthorized access: %v", err)
+				ctx.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: err.Error()})
+				return
+			}
+		}
+
+		// The API key authenticates the caller to the gateway only
+		ctx.Request.Header.Del(middleware.APIKeyHeader)
+
+		// Forward the request path unless the route rewrites it
+		path, ok := upstreamPath(route, ctx.Request.URL.Path, ctx.Params)
+		if !ok {
+			log.Printf("Controller: Proxy path %s leaves the route %s", ctx.Request.URL.Path, route.Path)
+			ctx.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid path"})
+			return
+		}
+
+		err := c.service.Proxy(ctx.Request.Context(), route.Upstream, path, route.Timeout, identity, ctx.Writer, ctx.Request)
+		if err != nil {
+			log.Printf("Controller: Error proxying request: %v", err)
+			status := serviceErrorStatus(ctx, err)
+			if status == http.StatusInternalServerError {
+				status = http.StatusBadGateway
+			}
+			ctx.JSON(status, model.ErrorResponse{Error: err.Error()})
+		}
+	}
+}
+
+// upstreamPath returns the cleaned upstream path of a proxy request, the request path or its rewrite.
+// It reports false when . or .. segments in the parameters take the path out of the static prefix
+// of the route, which is the only part its policy was checked for.
+func upstreamPath(route config.ProxyRoute, requestPath string, params gin.Params) (string, bool) {
+	template, forwarded := route.Path, requestPath
+	if route.Rewrite != "" {
+		template, forwarded = route.Rewrite, rewritePath(route.Rewrite, params)
+	}
+
+	cleaned := path.Clean(forwarded)
+	if strings.HasSuffix(forwarded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	prefix := strings.TrimSuffix(staticPrefix(template), "/")
+	return cleaned, cleaned == prefix || strings.HasPrefix(cleaned, prefix+"/")
+}
+
+// staticPrefix returns the segments of a route template before its first parameter
+func staticPrefix(template string) string {
+	segments := strings.Split(template, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return template
+}
+
+// rewritePath fills the :name and *name parameters of a rewrite template from the route parameters
+func rewritePath(rewrite string, params gin.Params) string {
+	path := rewrite
+	for _, param := range params {
+		path = replaceParam(path, param)
+	}
+	return path
+}
+
+// replaceParam replaces the :name or *name segment of a path with the parameter value
+func replaceParam(path string, param gin.Param) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == ":"+param.Key {
+			segments[i] = param.Value
+		} else if segment == "*"+param.Key {
+			// Catch-all values start with a slash
+			segments[i] = strings.TrimPrefix(param.Value, "/")
+		}
+	}
+	return strings.Join(segments, "/")
+}
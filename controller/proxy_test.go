@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// proxyService records the upstream path of the proxied requests
+type proxyService struct {
+	fakeService
+	paths []string
+}
+
+func (s *proxyService) Proxy(_ context.Context, _, path string, _ time.Duration, _ *model.Identity, w http.ResponseWriter, _ *http.Request) error {
+	s.paths = append(s.paths, path)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func TestProxyPathStaysUnderTheRoute(t *testing.T) {
+	routes := []config.ProxyRoute{
+		{Method: http.MethodGet, Path: "/reports/*path", Upstream: "ml", Rewrite: "/internal/reports/*path"},
+		{Method: http.MethodGet, Path: "/files/:name", Upstream: "ml", Rewrite: "/static/files/:name"},
+		{Method: http.MethodGet, Path: "/public/*path", Upstream: "ml"},
+	}
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantPath   string
+	}{
+		{"rewritten catch-all", "/reports/daily/summary", http.StatusOK, "/internal/reports/daily/summary"},
+		{"dot segments inside the route", "/reports/daily/../weekly/", http.StatusOK, "/internal/reports/weekly/"},
+		{"catch-all escaping the rewrite", "/reports/../../admin/users", http.StatusBadRequest, ""},
+		{"encoded dot segments", "/reports/%2e%2e/%2E%2E/admin", http.StatusBadRequest, ""},
+		{"parameter escaping the rewrite", "/files/..", http.StatusBadRequest, ""},
+		{"forwarded path escaping the route", "/public/../api/v1/admin/health", http.StatusBadRequest, ""},
+		{"forwarded path", "/public/docs/index.html", http.StatusOK, "/public/docs/index.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			svc := &proxyService{}
+			router := gin.New()
+			NewController(svc, router, &config.Config{}).registerProxyRoutes(routes, nil, func(*gin.Context) {}, nil)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			switch {
+			case tt.wantPath == "" && len(svc.paths) != 0:
+				t.Errorf("request forwarded to %v", svc.paths)
+			case tt.wantPath != "" && (len(svc.paths) != 1 || svc.paths[0] != tt.wantPath):
+				t.Errorf("forwarded to %v, want %s", svc.paths, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestProxyRoutesRejectCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []config.ProxyRoute
+		wantErr bool
+	}{
+		{"separate path", []config.ProxyRoute{{Method: http.MethodGet, Path: "/api/v1/reports/:id"}}, false},
+		{"static next to a gateway parameter", []config.ProxyRoute{{Method: http.MethodGet, Path: "/api/v1/train/latest"}}, false},
+		{"gateway path of another method", []config.ProxyRoute{{Method: http.MethodPut, Path: "/api/v1/predict"}}, false},
+		{"same gateway route", []config.ProxyRoute{{Method: http.MethodPost, Path: "/api/v1/predict"}}, true},
+		{"gateway parameter renamed", []config.ProxyRoute{{Method: http.MethodGet, Path: "/api/v1/train/:job"}}, true},
+		{"catch-all over gateway routes", []config.ProxyRoute{{Method: http.MethodGet, Path: "/api/*path"}}, true},
+		{"health route", []config.ProxyRoute{{Method: http.MethodGet, Path: "/health/ready"}}, true},
+		{"catch-all next to a proxy route", []config.ProxyRoute{{Method: http.MethodGet, Path: "/reports/daily"}, {Method: http.MethodGet, Path: "/reports/*path"}}, true},
+		{"duplicate proxy route", []config.ProxyRoute{{Method: http.MethodGet, Path: "/reports"}, {Method: http.MethodGet, Path: "/reports"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			cfg := &config.Config{}
+			controller := NewController(&fakeService{}, gin.New(), cfg)
+
+			err := controller.RegisterRoutes(func(*gin.Context) {}, middleware.NewRateLimiter(cfg), tt.routes)
+			if tt.wantErr && err == nil {
+				t.Error("RegisterRoutes succeeded, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("RegisterRoutes: %v", err)
+			}
+		})
+	}
+}
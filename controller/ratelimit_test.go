@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+)
+
+func TestBatchPredictionsCountPerItem(t *testing.T) {
+	var upload bytes.Buffer
+	writer := multipart.NewWriter(&upload)
+	file, err := writer.CreateFormFile("file", "products.csv")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	file.Write([]byte("product_name,brand,category,region,seller,month,quarter\nP1,B,C,R,S,1,1\nP2,B,C,R,S,1,1\nP3,B,C,R,S,1,1\n"))
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+	}{
+		{"batch", "/api/v1/predict/batch", "application/json", `{"items":[{},{},{}]}`},
+		{"CSV", "/api/v1/predict/csv", writer.FormDataContentType(), upload.String()},
+	}
+	for _, tt := range tests {
+		// The three items spend a burst of three, not of four
+		for _, burst := range []int{3, 4} {
+			want := http.StatusTooManyRequests
+			if burst > 3 {
+				want = http.StatusOK
+			}
+			limits := config.RateLimitConfig{User: config.RateLimit{Rate: 0.001, Burst: burst}}
+			router := newRateLimitedTestRouter(t, &fakeService{}, limits)
+			token := signTestToken(t, middleware.RoleUser)
+
+			upload := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			upload.Header.Set("Content-Type", tt.contentType)
+			upload.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, upload)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("%s with burst %d: status = %d, want %d, body: %s", tt.name, burst, recorder.Code, http.StatusOK, recorder.Body.String())
+			}
+
+			predict := httptest.NewRequest(http.MethodPost, "/api/v1/predict", strings.NewReader(`{}`))
+			predict.Header.Set("Content-Type", "application/json")
+			predict.Header.Set("Authorization", "Bearer "+token)
+			recorder = httptest.NewRecorder()
+			router.ServeHTTP(recorder, predict)
+			if recorder.Code != want {
+				t.Errorf("prediction after %s with burst %d: status = %d, want %d", tt.name, burst, recorder.Code, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/service"
+)
+
+const testJWTSecret = "test-secret"
+
+// Batch limits of the test router
+const (
+	testBatchMaxItems  = 5
+	testMaxUploadBytes = 4096
+)
+
+// Raw API keys known to fakeService
+const (
+	scopelessAPIKey  = "gw_scopeless"
+	allScopesAPIKey  = "gw_all_scopes"
+	unknownAPIKeyErr = "unknown API key"
+)
+
+// fakeService answers every call of the protected routes with an empty success
+type fakeService struct {
+	service.Service
+}
+
+func (f *fakeService) IsTokenRevoked(context.Context, string, uuid.UUID, time.Time) bool {
+	return false
+}
+
+func (f *fakeService) AuthenticateAPIKey(_ context.Context, rawKey string) (*model.APIKey, error) {
+	switch rawKey {
+	case scopelessAPIKey:
+		return &model.APIKey{ID: uuid.New(), UserID: uuid.New()}, nil
+	case allScopesAPIKey:
+		return &model.APIKey{ID: uuid.New(), UserID: uuid.New(), Scopes: model.APIKeyScopes}, nil
+	default:
+		return nil, errors.New(unknownAPIKeyErr)
+	}
+}
+
+func (f *fakeService) Predict(context.Context, *model.Identity, *model.PredictionRequest, bool) (*model.PredictionResult, error) {
+	return &model.PredictionResult{}, nil
+}
+
+func (f *fakeService) PredictMinimal(context.Context, *model.Identity, *model.PredictionRequestMinimal, bool) (*model.PredictionResult, error) {
+	return &model.PredictionResult{}, nil
+}
+
+func (f *fakeService) PredictBatch(_ context.Context, _ *model.Identity, request *model.BatchPredictionRequest, _ bool) (*model.BatchPredictionResponse, error) {
+	return &model.BatchPredictionResponse{Results: make([]model.BatchPredictionItem, len(request.Items))}, nil
+}
+
+func (f *fakeService) PredictRequests(_ context.Context, _ *model.Identity, requests []model.PredictionRequest, _ bool) (*model.BatchPredictionResponse, error) {
+	return &model.BatchPredictionResponse{Results: make([]model.BatchPredictionItem, len(requests))}, nil
+}
+
+func (f *fakeService) GetModelStatus(context.Context, *model.Identity) (*model.ModelStatus, error) {
+	return &model.ModelStatus{}, nil
+}
+
+func (f *fakeService) StartTrainingJob(context.Context, *model.Identity) (*model.TrainingJob, error) {
+	return &model.TrainingJob{ID: uuid.New()}, nil
+}
+
+func (f *fakeService) GetTrainingJob(_ context.Context, jobID uuid.UUID) (*model.TrainingJob, error) {
+	return &model.TrainingJob{ID: jobID}, nil
+}
+
+func (f *fakeService) ListTrainingJobs(context.Context, int) ([]model.TrainingJob, error) {
+	return nil, nil
+}
+
+func (f *fakeService) GetUserStatistics(context.Context, uuid.UUID) (*model.UserStatistics, error) {
+	return &model.UserStatistics{}, nil
+}
+
+func (f *fakeService) CreateAPIKey(context.Context, uuid.UUID, *model.CreateAPIKeyRequest) (*model.CreateAPIKeyResponse, error) {
+	return &model.CreateAPIKeyResponse{}, nil
+}
+
+func (f *fakeService) ListAPIKeys(context.Context, uuid.UUID) ([]model.APIKey, error) {
+	return nil, nil
+}
+
+func (f *fakeService) RevokeAPIKey(context.Context, uuid.UUID, uuid.UUID) error {
+	return nil
+}
+
+func (f *fakeService) RevokeUserTokens(_ context.Context, userID uuid.UUID) (*model.TokenRevocation, error) {
+	return &model.TokenRevocation{UserID: userID}, nil
+}
+
+func (f *fakeService) GetLoginLockouts(context.Context) []model.LoginLockout {
+	return nil
+}
+
+func (f *fakeService) ClearLoginLockout(context.Context, string, string) bool {
+	return true
+}
+
+func (f *fakeService) GetDependencyStatus(context.Context) []model.DependencyStatus {
+	return nil
+}
+
+func (f *fakeService) GetUpstreamStatus(context.Context) []model.UpstreamStatus {
+	return nil
+}
+
+// newTestRouter registers the routes of a controller backed by the service, authenticating
+// with the real middleware and HS256 tokens signed with testJWTSecret
+func newTestRouter(t *testing.T, svc service.Service) *gin.Engine {
+	t.Helper()
+	return newRateLimitedTestRouter(t, svc, config.RateLimitConfig{})
+}
+
+// newRateLimitedTestRouter is newTestRouter with rate limits
+func newRateLimitedTestRouter(t *testing.T, svc service.Service, limits config.RateLimitConfig) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		JWTSecret: testJWTSecret,
+		JWT:       config.JWTConfig{Algorithms: []string{"HS256"}},
+		RateLimit: limits,
+		Batch:     config.BatchConfig{MaxItems: testBatchMaxItems, MaxUploadBytes: testMaxUploadBytes},
+	}
+	keys, err := middleware.NewKeySet(cfg)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	credentials, ok := svc.(middleware.CredentialStore)
+	if !ok {
+		t.Fatal("service does not implement middleware.CredentialStore")
+	}
+
+	router := gin.New()
+	if err := NewController(svc, router, cfg).RegisterRoutes(middleware.AuthMiddleware(cfg, keys, credentials), middleware.NewRateLimiter(cfg), nil); err != nil {
+		t.Fatalf("RegisterRoutes: %v", err)
+	}
+	return router
+}
+
+// signTestToken returns an access token of a new user with the role
+func signTestToken(t *testing.T, role string) string {
+	t.Helper()
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.JWTClaims{
+		UserID: uuid.NewString(),
+		Email:  role + "@example.com",
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	})
+	signed, err := token.SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// routeRequest is a valid request to a protected route
+type routeRequest struct {
+	method      string
+	pattern     string
+	path        string
+	contentType string
+	body        string
+	// adminOnly routes reject users without the admin role
+	adminOnly bool
+	// scoped routes accept API keys with one of their scopes
+	scoped bool
+}
+
+// csvBody builds a multipart upload of a one-row CSV file
+func csvBody(t *testing.T) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	file, err := writer.CreateFormFile("file", "products.csv")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	file.Write([]byte("product_name,brand,category,region,seller,month,quarter\nP,B,C,R,S,1,1\n"))
+	writer.Close()
+	return writer.FormDataContentType(), body.String()
+}
+
+// protectedRoutes lists a valid request to every route behind authentication
+func protectedRoutes(t *testing.T) []routeRequest {
+	csvContentType, csvUpload := csvBody(t)
+	id := uuid.NewString()
+	return []routeRequest{
+		{method: http.MethodPost, pattern: "/api/v1/predict", path: "/api/v1/predict", body: `{}`, scoped: true},
+		{method: http.MethodPost, pattern: "/api/v1/predict/minimal", path: "/api/v1/predict/minimal", body: `{}`, scoped: true},
+		{method: http.MethodPost, pattern: "/api/v1/predict/batch", path: "/api/v1/predict/batch", body: `{"items":[{}]}`, scoped: true},
+		{method: http.MethodPost, pattern: "/api/v1/predict/csv", path: "/api/v1/predict/csv", contentType: csvContentType, body: csvUpload, scoped: true},
+		{method: http.MethodPost, pattern: "/api/v1/train", path: "/api/v1/train", adminOnly: true},
+		{method: http.MethodGet, pattern: "/api/v1/train", path: "/api/v1/train", adminOnly: true},
+		{method: http.MethodGet, pattern: "/api/v1/train/:id", path: "/api/v1/train/" + id, adminOnly: true},
+		{method: http.MethodGet, pattern: "/api/v1/status", path: "/api/v1/status", scoped: true},
+		{method: http.MethodGet, pattern: "/api/v1/statistics/user", path: "/api/v1/statistics/user", scoped: true},
+		{method: http.MethodPost, pattern: "/api/v1/api-keys", path: "/api/v1/api-keys", body: `{"name":"job","scopes":["predict"]}`},
+		{method: http.MethodGet, pattern: "/api/v1/api-keys", path: "/api/v1/api-keys"},
+		{method: http.MethodDelete, pattern: "/api/v1/api-keys/:id", path: "/api/v1/api-keys/" + id},
+		{method: http.MethodPost, pattern: "/api/v1/admin/users/:id/revoke-tokens", path: "/api/v1/admin/users/" + id + "/revoke-tokens", adminOnly: true},
+		{method: http.MethodGet, pattern: "/api/v1/admin/login-lockouts", path: "/api/v1/admin/login-lockouts", adminOnly: true},
+		{method: http.MethodDelete, pattern: "/api/v1/admin/login-lockouts", path: "/api/v1/admin/login-lockouts?email=test@example.com", adminOnly: true},
+		{method: http.MethodGet, pattern: "/api/v1/admin/health", path: "/api/v1/admin/health", adminOnly: true},
+	}
+}
+
+func TestProtectedRoutesCoverPolicies(t *testing.T) {
+	covered := map[string]routeRequest{}
+	for _, route := range protectedRoutes(t) {
+		covered[middleware.RouteKey(route.method, route.pattern)] = route
+	}
+
+	for key, policy := range routePolicies {
+		route, exists := covered[key]
+		if !exists {
+			t.Errorf("route policy %q has no test request", key)
+			continue
+		}
+		if route.adminOnly != (len(policy.Roles) > 0) || route.scoped != (len(policy.Scopes) > 0) {
+			t.Errorf("test request of %q does not match its policy %+v", key, policy)
+		}
+	}
+	// Role and scope restrictions only come from the table
+	for key, route := range covered {
+		if _, exists := routePolicies[key]; !exists && (route.adminOnly || route.scoped) {
+			t.Errorf("route %q is restricted without a route policy", key)
+		}
+	}
+
+	// Every authenticated route of the router is tested
+	router := newTestRouter(t, &fakeService{})
+	for _, info := range router.Routes() {
+		if !strings.HasPrefix(info.Path, "/api/") {
+			continue
+		}
+		if _, exists := covered[middleware.RouteKey(info.Method, info.Path)]; !exists {
+			t.Errorf("route %s %s has no test request", info.Method, info.Path)
+		}
+	}
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	router := newTestRouter(t, &fakeService{})
+	adminToken := signTestToken(t, middleware.RoleAdmin)
+	userToken := signTestToken(t, middleware.RoleUser)
+
+	callers := []struct {
+		name    string
+		headers map[string]string
+		allowed func(route routeRequest) bool
+	}{
+		{
+			name:    "admin",
+			headers: map[string]string{"Authorization": "Bearer " + adminToken},
+			allowed: func(routeRequest) bool { return true },
+		},
+		{
+			name:    "user",
+			headers: map[string]string{"Authorization": "Bearer " + userToken},
+			allowed: func(route routeRequest) bool { return !route.adminOnly },
+		},
+		{
+			name:    "API key without scopes",
+			headers: map[string]string{middleware.APIKeyHeader: scopelessAPIKey},
+			allowed: func(routeRequest) bool { return false },
+		},
+		{
+			name:    "API key with all scopes",
+			headers: map[string]string{middleware.APIKeyHeader: allScopesAPIKey},
+			allowed: func(route routeRequest) bool { return route.scoped },
+		},
+	}
+
+	for _, route := range protectedRoutes(t) {
+		for _, caller := range callers {
+			t.Run(route.method+" "+route.pattern+" as "+caller.name, func(t *testing.T) {
+				request := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+				request.Header.Set("Content-Type", "application/json")
+				if route.contentType != "" {
+					request.Header.Set("Content-Type", route.contentType)
+				}
+				for name, value := range caller.headers {
+					request.Header.Set(name, value)
+				}
+
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, request)
+
+				if caller.allowed(route) {
+					if recorder.Code < 200 || recorder.Code > 299 {
+						t.Errorf("status = %d, want 2xx, body: %s", recorder.Code, recorder.Body.String())
+					}
+				} else if recorder.Code != http.StatusForbidden {
+					t.Errorf("status = %d, want %d, body: %s", recorder.Code, http.StatusForbidden, recorder.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestProtectedRoutesRequireAuthentication(t *testing.T) {
+	router := newTestRouter(t, &fakeService{})
+	for _, route := range protectedRoutes(t) {
+		t.Run(route.method+" "+route.pattern, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(route.method, route.path, strings.NewReader(route.body)))
+			if recorder.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
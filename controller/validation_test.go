@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/graduate-work-mirea/api-gateway/middleware"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/service"
+)
+
+// validatingService rejects the prediction requests that fail validation, like the real service
+type validatingService struct {
+	fakeService
+}
+
+func (s *validatingService) Predict(_ context.Context, _ *model.Identity, request *model.PredictionRequest, _ bool) (*model.PredictionResult, error) {
+	if fieldErrors := request.Validate(); len(fieldErrors) > 0 {
+		return nil, &service.ValidationError{Errors: fieldErrors}
+	}
+	return &model.PredictionResult{}, nil
+}
+
+func TestValidationErrorsReportLinesOfCSVFilesOnly(t *testing.T) {
+	var upload bytes.Buffer
+	writer := multipart.NewWriter(&upload)
+	file, err := writer.CreateFormFile("file", "products.csv")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	file.Write([]byte("product_name,brand,category,region,seller,month,quarter\nP,B,C,R,S,13,1\n"))
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantLine    bool
+	}{
+		{"JSON request", "/api/v1/predict", "application/json", `{"product_name":"P","brand":"B","category":"C","region":"R","seller":"S","month":13,"quarter":1}`, false},
+		{"CSV upload", "/api/v1/predict/csv", writer.FormDataContentType(), upload.String(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, &validatingService{})
+			request := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(tt.body))
+			request.Header.Set("Content-Type", tt.contentType)
+			request.Header.Set("Authorization", "Bearer "+signTestToken(t, middleware.RoleUser))
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusUnprocessableEntity, recorder.Body.String())
+			}
+			var response struct {
+				Errors []map[string]any `json:"errors"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(response.Errors) == 0 {
+				t.Fatal("no validation errors")
+			}
+			for _, fieldError := range response.Errors {
+				if fieldError["field"] != "month" {
+					t.Errorf("field = %v, want month", fieldError["field"])
+				}
+				if _, hasLine := fieldError["line"]; hasLine != tt.wantLine {
+					t.Errorf("error %v has a line: %t, want %t", fieldError, hasLine, tt.wantLine)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -12,8 +14,54 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
 )
 
+// APIKeyHeader carries the API key of machine clients
+const APIKeyHeader = "X-API-Key"
+
+// Authentication methods stored in the context
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
+)
+
+// Authentication error codes returned with 401 responses
+const (
+	ErrCodeMissingToken          = "missing_token"
+	ErrCodeInvalidAuthHeader     = "invalid_authorization_header"
+	ErrCodeMalformedToken        = "malformed_token"
+	ErrCodeInvalidSignature      = "invalid_signature"
+	ErrCodeUnverifiableToken     = "unverifiable_token"
+	ErrCodeMissingClaim          = "missing_claim"
+	ErrCodeTokenExpired          = "token_expired"
+	ErrCodeTokenNotYetValid      = "token_not_yet_valid"
+	ErrCodeTokenUsedBeforeIssued = "token_used_before_issued"
+	ErrCodeInvalidIssuer         = "invalid_issuer"
+	ErrCodeInvalidAudience       = "invalid_audience"
+	ErrCodeInvalidToken          = "invalid_token"
+	ErrCodeInvalidUserID         = "invalid_user_id"
+	ErrCodeTokenRevoked          = "token_revoked"
+	ErrCodeInvalidAPIKey         = "invalid_api_key"
+)
+
+// tokenErrors maps token validation errors to their code and message, in the order they are checked
+var tokenErrors = []struct {
+	err     error
+	code    string
+	message string
+}{
+	{jwt.ErrTokenMalformed, ErrCodeMalformedToken, "token is malformed"},
+	{jwt.ErrTokenSignatureInvalid, ErrCodeInvalidSignature, "token signature is invalid"},
+	{jwt.ErrTokenUnverifiable, ErrCodeUnverifiableToken, "token cannot be verified"},
+	{jwt.ErrTokenRequiredClaimMissing, ErrCodeMissingClaim, "token is missing a required claim"},
+	{jwt.ErrTokenExpired, ErrCodeTokenExpired, "token has expired"},
+	{jwt.ErrTokenNotValidYet, ErrCodeTokenNotYetValid, "token is not valid yet"},
+	{jwt.ErrTokenUsedBeforeIssued, ErrCodeTokenUsedBeforeIssued, "token used before issued"},
+	{jwt.ErrTokenInvalidIssuer, ErrCodeInvalidIssuer, "token has invalid issuer"},
+	{jwt.ErrTokenInvalidAudience, ErrCodeInvalidAudience, "token has invalid audience"},
+}
+
 // JWTClaims represents the claims in a JWT
 type JWTClaims struct {
 	UserID string `json:"user_id"`
@@ -22,18 +70,32 @@ type JWTClaims struct {
 	jwt.RegisteredClaims
 }
 
+// CredentialStore holds the gateway-side state of issued credentials
+type CredentialStore interface {
+	IsTokenRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) bool
+	AuthenticateAPIKey(ctx context.Context, rawKey string) (*model.APIKey, error)
+}
+
 // AuthMiddleware creates a middleware for authentication
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
-	log.Println("Middleware: Creating authentication middleware")
+func AuthMiddleware(cfg *config.Config, keys *KeySet, credentials CredentialStore) gin.HandlerFunc {
+	log.Printf("Middleware: Creating authentication middleware, accepted algorithms: %v, issuer: %q, audience: %q, leeway: %v",
+		cfg.JWT.Algorithms, cfg.JWT.Issuer, cfg.JWT.Audience, cfg.JWT.Leeway)
+	parser := jwt.NewParser(parserOptions(cfg)...)
 	return func(c *gin.Context) {
 		path := c.Request.URL.Path
 		log.Printf("Middleware: Processing authentication for path: %s", path)
 
+		// Machine clients authenticate with an API key instead of a token
+		if rawKey := c.GetHeader(APIKeyHeader); rawKey != "" {
+			authenticateAPIKey(c, credentials, rawKey)
+			return
+		}
+
 		// Get the JWT token from the Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			log.Printf("Middleware: Authorization header is missing for path: %s", path)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header is required"})
+			abortUnauthorized(c, ErrCodeMissingToken, "authorization header is required")
 			return
 		}
 
@@ -41,45 +103,32 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			log.Printf("Middleware: Invalid authorization header format for path: %s", path)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
+			abortUnauthorized(c, ErrCodeInvalidAuthHeader, "invalid authorization header format")
 			return
 		}
 
-		// Parse the JWT token
+		// Parse the JWT token, this checks the signature and the exp, nbf, iat, iss and aud claims
 		tokenString := parts[1]
 		claims := &JWTClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				log.Printf("Middleware: Unexpected signing method: %v for path: %s", token.Header["alg"], path)
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(cfg.JWTSecret), nil
-		})
-
-		// Check if the token is valid
+		token, err := parser.ParseWithClaims(tokenString, claims, keys.Keyfunc)
 		if err != nil {
-			if errors.Is(err, jwt.ErrTokenExpired) {
-				log.Printf("Middleware: Token has expired for path: %s", path)
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has expired"})
-				return
-			}
+			code, message := tokenError(err)
 			log.Printf("Middleware: Invalid token: %v for path: %s", err, path)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			abortUnauthorized(c, code, message)
 			return
 		}
 
 		// Check if the token is valid
 		if !token.Valid {
 			log.Printf("Middleware: Token is invalid for path: %s", path)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			abortUnauthorized(c, ErrCodeInvalidToken, "invalid token")
 			return
 		}
 
-		// Check if the token has expired
-		if time.Now().Unix() > claims.ExpiresAt.Unix() {
-			log.Printf("Middleware: Token has expired for path: %s", path)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has expired"})
+		// Tokens the gateway forwards to upstream services must not be replayed against it
+		if cfg.InternalToken.Issuer != "" && claims.Issuer == cfg.InternalToken.Issuer {
+			log.Printf("Middleware: Internal gateway token used for path: %s", path)
+			abortUnauthorized(c, ErrCodeInvalidIssuer, "internal gateway tokens are not accepted")
 			return
 		}
 
@@ -87,7 +136,19 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		userID, err := uuid.Parse(claims.UserID)
 		if err != nil {
 			log.Printf("Middleware: Invalid user ID: %v for path: %s", err, path)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid user ID"})
+			abortUnauthorized(c, ErrCodeInvalidUserID, "invalid user ID")
+			return
+		}
+
+		// Check if the token has been revoked
+		tokenID := TokenID(tokenString, claims)
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		if credentials.IsTokenRevoked(c.Request.Context(), tokenID, userID, issuedAt) {
+			log.Printf("Middleware: Token has been revoked for user: %s, path: %s", claims.UserID, path)
+			abortUnauthorized(c, ErrCodeTokenRevoked, "token has been revoked")
 			return
 		}
 
@@ -95,12 +156,48 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		c.Set("userID", userID)
 		c.Set("email", claims.Email)
 		c.Set("role", claims.Role)
+		c.Set("tokenID", tokenID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		c.Set("authMethod", AuthMethodJWT)
 
 		log.Printf("Middleware: Authentication successful for user: %s, role: %s, path: %s", claims.UserID, claims.Role, path)
 		c.Next()
 	}
 }
 
+// parserOptions builds the JWT parser options from the configuration. Tokens must have
+// an expiry, and the iat claim is rejected when it is in the future.
+func parserOptions(cfg *config.Config) []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.JWT.Algorithms),
+		jwt.WithLeeway(cfg.JWT.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.JWT.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.JWT.Issuer))
+	}
+	if cfg.JWT.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.JWT.Audience))
+	}
+	return options
+}
+
+// tokenError returns the error code and message for a token validation error
+func tokenError(err error) (string, string) {
+	for _, tokenErr := range tokenErrors {
+		if errors.Is(err, tokenErr.err) {
+			return tokenErr.code, tokenErr.message
+		}
+	}
+	return ErrCodeInvalidToken, "invalid token"
+}
+
+// abortUnauthorized aborts the request with 401 and an error code
+func abortUnauthorized(c *gin.Context, code, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, model.ErrorResponse{Error: message, Code: code})
+}
+
 // GetUserID gets the user ID from the context
 func GetUserID(c *gin.Context) (uuid.UUID, error) {
 	userID, exists := c.Get("userID")
@@ -111,3 +208,88 @@ func GetUserID(c *gin.Context) (uuid.UUID, error) {
 
 	return userID.(uuid.UUID), nil
 }
+
+// authenticateAPIKey authenticates the request with an API key and stores its owner in the context
+func authenticateAPIKey(c *gin.Context, credentials CredentialStore, rawKey string) {
+	path := c.Request.URL.Path
+
+	apiKey, err := credentials.AuthenticateAPIKey(c.Request.Context(), rawKey)
+	if err != nil {
+		log.Printf("Middleware: Invalid API key: %v for path: %s", err, path)
+		abortUnauthorized(c, ErrCodeInvalidAPIKey, "invalid API key")
+		return
+	}
+
+	// Store the key owner in the context, API keys carry no role
+	c.Set("userID", apiKey.UserID)
+	c.Set("apiKeyID", apiKey.ID)
+	c.Set("scopes", apiKey.Scopes)
+	c.Set("authMethod", AuthMethodAPIKey)
+
+	log.Printf("Middleware: API key authentication successful for user: %s, key: %s, path: %s", apiKey.UserID, apiKey.ID, path)
+	c.Next()
+}
+
+// TokenID returns the identifier used to revoke a token: its jti claim,
+// or the SHA-256 of the raw token when the issuer does not set one
+func TokenID(tokenString string, claims *JWTClaims) string {
+	if claims.ID != "" {
+		return claims.ID
+	}
+
+	sum := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetTokenID gets the ID and expiry time of the access token from the context
+func GetTokenID(c *gin.Context) (string, time.Time, error) {
+	tokenID, exists := c.Get("tokenID")
+	if !exists {
+		log.Println("Middleware: Token ID not found in context")
+		return "", time.Time{}, errors.New("token ID not found in context")
+	}
+
+	expiresAt, _ := c.Get("tokenExpiresAt")
+	return tokenID.(string), expiresAt.(time.Time), nil
+}
+
+// GetAuthMethod gets the authentication method of the request from the context
+func GetAuthMethod(c *gin.Context) string {
+	method, _ := c.Get("authMethod")
+	methodStr, _ := method.(string)
+	return methodStr
+}
+
+// GetScopes gets the scopes of the API key from the context
+func GetScopes(c *gin.Context) []string {
+	scopes, _ := c.Get("scopes")
+	scopesSlice, _ := scopes.([]string)
+	return scopesSlice
+}
+
+// GetIdentity gets the authenticated caller from the context
+func GetIdentity(c *gin.Context) (*model.Identity, error) {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return nil, err
+	}
+
+	email, _ := c.Get("email")
+	emailStr, _ := email.(string)
+	return &model.Identity{
+		UserID: userID,
+		Email:  emailStr,
+		Role:   GetRole(c),
+	}, nil
+}
+
+// StripIdentityHeaders creates a middleware that drops client-supplied identity headers,
+// so only the identity set by the gateway reaches upstream services
+func StripIdentityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, header := range model.IdentityHeaders {
+			c.Request.Header.Del(header)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// noCredentials is a credential store without revoked tokens or API keys
+type noCredentials struct{}
+
+func (noCredentials) IsTokenRevoked(context.Context, string, uuid.UUID, time.Time) bool {
+	return false
+}
+
+func (noCredentials) AuthenticateAPIKey(context.Context, string) (*model.APIKey, error) {
+	return nil, errors.New("unknown API key")
+}
+
+func TestAuthMiddlewareRejectsInternalTokens(t *testing.T) {
+	const secret = "user-token-secret"
+	cfg := &config.Config{
+		JWTSecret:     secret,
+		JWT:           config.JWTConfig{Algorithms: []string{"HS256"}},
+		InternalToken: config.InternalTokenConfig{Issuer: "api-gateway"},
+	}
+	keys, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/status", AuthMiddleware(cfg, keys, noCredentials{}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	userClaims := testClaims(RoleAdmin)
+	internalClaims := testClaims(RoleAdmin)
+	internalClaims.Issuer = cfg.InternalToken.Issuer
+
+	tests := []struct {
+		name   string
+		claims JWTClaims
+		want   int
+	}{
+		{"user token", userClaims, http.StatusOK},
+		{"forwarded internal token", internalClaims, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
+			request.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, []byte(secret), "", tt.claims))
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
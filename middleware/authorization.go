@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles issued by the auth service
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Policy describes who is allowed to call a route
+type Policy struct {
+	// Roles allowed to call the route with a token, empty means any authenticated user
+	Roles []string
+	// Scopes allowing API keys to call the route, empty means API keys are rejected
+	Scopes []string
+}
+
+// PolicyTable maps a route key ("METHOD /full/path" as registered in gin) to its policy
+type PolicyTable map[string]Policy
+
+// RouteKey builds the PolicyTable key for a method and a registered route path
+func RouteKey(method, path string) string {
+	return method + " " + path
+}
+
+// PolicyMiddleware creates a middleware that enforces the policy registered for the matched route.
+// Routes without an entry in the table are open to every user authenticated with a token.
+func PolicyMiddleware(policies PolicyTable) gin.HandlerFunc {
+	log.Printf("Middleware: Creating policy middleware with %d route policies", len(policies))
+	return func(c *gin.Context) {
+		authorize(c, policies[RouteKey(c.Request.Method, c.FullPath())])
+	}
+}
+
+// RequireRole creates a middleware that only lets through users with one of the given roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	policy := Policy{Roles: roles}
+	return func(c *gin.Context) {
+		authorize(c, policy)
+	}
+}
+
+// authorize aborts the request with 403 unless the caller satisfies the policy
+func authorize(c *gin.Context, policy Policy) {
+	if GetAuthMethod(c) == AuthMethodAPIKey {
+		if !hasAnyScope(GetScopes(c), policy.Scopes) {
+			log.Printf("Middleware: Access denied for API key scopes: %v, path: %s", GetScopes(c), c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to call this route"})
+			return
+		}
+
+		c.Next()
+		return
+	}
+
+	role := GetRole(c)
+	if len(policy.Roles) > 0 && !slices.Contains(policy.Roles, role) {
+		log.Printf("Middleware: Access denied for role: %q, path: %s", role, c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+		return
+	}
+
+	c.Next()
+}
+
+// hasAnyScope reports whether any of the granted scopes is one of the allowed scopes
+func hasAnyScope(granted []string, allowed []string) bool {
+	for _, scope := range granted {
+		if slices.Contains(allowed, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRole gets the role of the authenticated user from the context
+func GetRole(c *gin.Context) string {
+	role, exists := c.Get("role")
+	if !exists {
+		return ""
+	}
+
+	roleStr, _ := role.(string)
+	return roleStr
+}
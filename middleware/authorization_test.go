@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// caller is the authenticated identity a test request is made with
+type caller struct {
+	name   string
+	role   string
+	scopes []string
+	apiKey bool
+}
+
+var (
+	adminCaller      = caller{name: "admin", role: RoleAdmin}
+	userCaller       = caller{name: "user", role: RoleUser}
+	scopelessKey     = caller{name: "API key without scopes", apiKey: true}
+	predictScopedKey = caller{name: "API key with predict scope", scopes: []string{"predict"}, apiKey: true}
+	noRoleCaller     = caller{name: "token without role"}
+)
+
+// authenticateAs stands in for AuthMiddleware and stores the caller in the context
+func authenticateAs(who caller) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userID", uuid.New())
+		if who.apiKey {
+			c.Set("scopes", who.scopes)
+			c.Set("authMethod", AuthMethodAPIKey)
+		} else {
+			c.Set("role", who.role)
+			c.Set("authMethod", AuthMethodJWT)
+		}
+		c.Next()
+	}
+}
+
+// serve makes a request through the handlers as the caller and returns the response status
+func serve(t *testing.T, who caller, method, pattern, path string, handlers ...gin.HandlerFunc) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handlers = append([]gin.HandlerFunc{authenticateAs(who)}, handlers...)
+	handlers = append(handlers, func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.Handle(method, pattern, handlers...)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder.Code
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	policies := PolicyTable{
+		RouteKey(http.MethodPost, "/admin/:id"): {Roles: []string{RoleAdmin}},
+		RouteKey(http.MethodPost, "/predict"):   {Scopes: []string{"predict"}},
+	}
+
+	tests := []struct {
+		method  string
+		pattern string
+		path    string
+		who     caller
+		want    int
+	}{
+		{http.MethodPost, "/admin/:id", "/admin/42", adminCaller, http.StatusOK},
+		{http.MethodPost, "/admin/:id", "/admin/42", userCaller, http.StatusForbidden},
+		{http.MethodPost, "/admin/:id", "/admin/42", noRoleCaller, http.StatusForbidden},
+		{http.MethodPost, "/admin/:id", "/admin/42", predictScopedKey, http.StatusForbidden},
+		{http.MethodPost, "/predict", "/predict", adminCaller, http.StatusOK},
+		{http.MethodPost, "/predict", "/predict", userCaller, http.StatusOK},
+		{http.MethodPost, "/predict", "/predict", predictScopedKey, http.StatusOK},
+		{http.MethodPost, "/predict", "/predict", scopelessKey, http.StatusForbidden},
+		// Routes without a policy are open to tokens and closed to API keys
+		{http.MethodGet, "/open", "/open", userCaller, http.StatusOK},
+		{http.MethodGet, "/open", "/open", predictScopedKey, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.pattern+" as "+tt.who.name, func(t *testing.T) {
+			got := serve(t, tt.who, tt.method, tt.pattern, tt.path, PolicyMiddleware(policies))
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		who  caller
+		want int
+	}{
+		{adminCaller, http.StatusOK},
+		{userCaller, http.StatusForbidden},
+		{noRoleCaller, http.StatusForbidden},
+		{scopelessKey, http.StatusForbidden},
+		{predictScopedKey, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.who.name, func(t *testing.T) {
+			got := serve(t, tt.who, http.MethodGet, "/admin", "/admin", RequireRole(RoleAdmin))
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+// jwksMinRefetchInterval limits how often an unknown kid can trigger a JWKS refetch
+const jwksMinRefetchInterval = 30 * time.Second
+
+// KeySet resolves the keys used to verify JWT signatures: HMAC secrets from the keyring,
+// a public key loaded from a PEM file and public keys from a JWKS document, selected by kid
+type KeySet struct {
+	hmacKeys    map[string][]byte
+	activeKeyID string
+	publicKey   crypto.PublicKey
+	jwksURL     string
+	httpClient  *http.Client
+
+	mutex       sync.RWMutex
+	jwksKeys    map[string]crypto.PublicKey
+	jwksFetched time.Time
+}
+
+// jsonWebKey represents a single key of a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewKeySet creates a key set from the configuration and starts refetching the JWKS document
+func NewKeySet(cfg *config.Config) (*KeySet, error) {
+	keys := &KeySet{
+		hmacKeys:    make(map[string][]byte),
+		activeKeyID: cfg.JWT.ActiveKeyID,
+		jwksURL:     cfg.JWT.JWKSURL,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		jwksKeys:    make(map[string]crypto.PublicKey),
+	}
+
+	// The plain secret is the active key unless the keyring names another one, without a
+	// secret no HMAC key is registered so HMAC signed tokens cannot be verified
+	for kid, secret := range cfg.JWT.HMACKeys {
+		keys.hmacKeys[kid] = []byte(secret)
+	}
+	if keys.activeKeyID == "" && cfg.JWTSecret != "" {
+		keys.hmacKeys[""] = []byte(cfg.JWTSecret)
+	}
+	log.Printf("Middleware: Loaded %d HMAC keys, active key ID: %q", len(keys.hmacKeys), keys.activeKeyID)
+
+	// Load the static public key
+	if cfg.JWT.PublicKeyFile != "" {
+		log.Printf("Middleware: Loading JWT public key from %s", cfg.JWT.PublicKeyFile)
+		publicKey, err := loadPublicKey(cfg.JWT.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load JWT public key: %w", err)
+		}
+		keys.publicKey = publicKey
+	}
+
+	// Load the JWKS document and keep it fresh
+	if keys.jwksURL != "" {
+		log.Printf("Middleware: Loading JWKS from %s", keys.jwksURL)
+		if err := keys.refreshJWKS(); err != nil {
+			return nil, fmt.Errorf("load JWKS: %w", err)
+		}
+
+		if cfg.JWT.JWKSRefreshInterval > 0 {
+			go func() {
+				ticker := time.NewTicker(cfg.JWT.JWKSRefreshInterval)
+				defer ticker.Stop()
+				for range ticker.C {
+					if err := keys.refreshJWKS(); err != nil {
+						log.Printf("Middleware: Error refreshing JWKS: %v", err)
+					}
+				}
+			}()
+		}
+	}
+
+	return keys, nil
+}
+
+// Keyfunc returns the key to verify the token with, based on its algorithm and kid
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return k.hmacKeyFor(token)
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		key, err := k.publicKeyFor(token)
+		if err != nil {
+			return nil, err
+		}
+		if !keyMatchesMethod(key, token.Method) {
+			return nil, fmt.Errorf("key type does not match signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+}
+
+// hmacKeyFor selects the HMAC secret for a token: the keyring entry with its kid,
+// or the active key when the token has no kid
+func (k *KeySet) hmacKeyFor(token *jwt.Token) ([]byte, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = k.activeKeyID
+	}
+
+	secret, exists := k.hmacKeys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown HMAC key ID: %s", kid)
+	}
+	return secret, nil
+}
+
+// publicKeyFor selects the public key for a token: the JWKS key with its kid,
+// or the static PEM key when the token has no kid
+func (k *KeySet) publicKeyFor(token *jwt.Token) (crypto.PublicKey, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.publicKey == nil {
+			return nil, errors.New("token has no kid and no static public key is configured")
+		}
+		return k.publicKey, nil
+	}
+
+	if key, exists := k.jwksKey(kid); exists {
+		return key, nil
+	}
+
+	// The issuer may have rotated its keys since the last fetch
+	if k.jwksURL != "" && k.jwksStale() {
+		log.Printf("Middleware: Unknown kid %q, refetching JWKS", kid)
+		if err := k.refreshJWKS(); err != nil {
+			log.Printf("Middleware: Error refreshing JWKS: %v", err)
+		}
+		if key, exists := k.jwksKey(kid); exists {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key ID: %s", kid)
+}
+
+// jwksKey looks up a JWKS key by kid
+func (k *KeySet) jwksKey(kid string) (crypto.PublicKey, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	key, exists := k.jwksKeys[kid]
+	return key, exists
+}
+
+// jwksStale reports whether the JWKS document may be refetched on an unknown kid
+func (k *KeySet) jwksStale() bool {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return time.Since(k.jwksFetched) > jwksMinRefetchInterval
+}
+
+// refreshJWKS fetches the JWKS document and replaces the known keys
+func (k *KeySet) refreshJWKS() error {
+	data, err := k.readJWKS()
+	if err != nil {
+		return err
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Middleware: Skipping JWKS key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	k.mutex.Lock()
+	k.jwksKeys = keys
+	k.jwksFetched = time.Now()
+	k.mutex.Unlock()
+
+	log.Printf("Middleware: Loaded %d keys from JWKS", len(keys))
+	return nil
+}
+
+// readJWKS reads the JWKS document from an http(s) URL or a local file
+func (k *KeySet) readJWKS() ([]byte, error) {
+	if !strings.HasPrefix(k.jwksURL, "http://") && !strings.HasPrefix(k.jwksURL, "https://") {
+		return os.ReadFile(strings.TrimPrefix(k.jwksURL, "file://"))
+	}
+
+	resp, err := k.httpClient.Get(k.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status code: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// publicKey converts the JWK to an RSA or EC public key
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// loadPublicKey reads an RSA or EC public key (or a certificate) from a PEM file
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// keyMatchesMethod reports whether the key can verify signatures of the signing method
+func keyMatchesMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+// placeholderSecret is the example JWT_SECRET of older configurations
+const placeholderSecret = "your_secret_key_here"
+
+// encodeBigInt encodes an integer as a JWK base64url value
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// writeJWKS writes a JWKS document with the keys to a temporary file and returns its path
+func writeJWKS(t *testing.T, keys ...jsonWebKey) string {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jsonWebKey{"keys": keys})
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write JWKS: %v", err)
+	}
+	return path
+}
+
+// testClaims returns valid claims of a new user with the role
+func testClaims(role string) JWTClaims {
+	now := time.Now()
+	return JWTClaims{
+		UserID: uuid.NewString(),
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+// signToken signs the claims with the method and key, setting the kid header when given
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims JWTClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// verify parses a token like AuthMiddleware does
+func verify(cfg *config.Config, keys *KeySet, tokenString string) error {
+	_, err := jwt.NewParser(parserOptions(cfg)...).ParseWithClaims(tokenString, &JWTClaims{}, keys.Keyfunc)
+	return err
+}
+
+func TestKeySetVerifiesTokensWithLocalJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+
+	path := writeJWKS(t,
+		jsonWebKey{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: encodeBigInt(rsaKey.N), E: encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+		jsonWebKey{Kty: "EC", Kid: "ec-1", Crv: "P-256", X: encodeBigInt(ecKey.X), Y: encodeBigInt(ecKey.Y)},
+		// Encryption keys are skipped
+		jsonWebKey{Kty: "RSA", Kid: "enc-1", Use: "enc", N: encodeBigInt(otherKey.N), E: encodeBigInt(big.NewInt(int64(otherKey.E)))},
+	)
+	cfg := &config.Config{JWT: config.JWTConfig{Algorithms: []string{"RS256", "ES256"}, JWKSURL: path}}
+	keys, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256 with known kid", signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", testClaims(RoleUser)), true},
+		{"ES256 with known kid", signToken(t, jwt.SigningMethodES256, ecKey, "ec-1", testClaims(RoleUser)), true},
+		{"RS256 with unknown kid", signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa-2", testClaims(RoleUser)), false},
+		{"RS256 signed with another key", signToken(t, jwt.SigningMethodRS256, otherKey, "rsa-1", testClaims(RoleUser)), false},
+		{"RS256 with encryption key", signToken(t, jwt.SigningMethodRS256, otherKey, "enc-1", testClaims(RoleUser)), false},
+		{"ES256 with RSA kid", signToken(t, jwt.SigningMethodES256, ecKey, "rsa-1", testClaims(RoleUser)), false},
+		{"RS256 without kid or static key", signToken(t, jwt.SigningMethodRS256, rsaKey, "", testClaims(RoleUser)), false},
+		{"HS256 not accepted", signToken(t, jwt.SigningMethodHS256, []byte(placeholderSecret), "", testClaims(RoleAdmin)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(cfg, keys, tt.token)
+			if tt.valid && err != nil {
+				t.Errorf("token rejected: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("token accepted")
+			}
+		})
+	}
+}
+
+func TestKeySetRejectsHMACTokensWithoutSecret(t *testing.T) {
+	// HS256 is accepted, but no secret is configured
+	cfg := &config.Config{JWT: config.JWTConfig{Algorithms: []string{"HS256", "RS256"}}}
+	keys, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	for _, secret := range []string{placeholderSecret, "any-secret"} {
+		token := signToken(t, jwt.SigningMethodHS256, []byte(secret), "", testClaims(RoleAdmin))
+		if err := verify(cfg, keys, token); err == nil {
+			t.Errorf("token signed with %q accepted", secret)
+		}
+	}
+}
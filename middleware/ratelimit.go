@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+// idleBucketTTL is how long an unused bucket is kept before it is dropped
+const idleBucketTTL = 10 * time.Minute
+
+// rateLimitChargeKey stores the bucket that let a request through in the context
+const rateLimitChargeKey = "rateLimitCharge"
+
+// rateLimitCharge identifies the bucket that let a request through
+type rateLimitCharge struct {
+	limiter *RateLimiter
+	key     string
+	limit   config.RateLimit
+}
+
+// RateLimiter keeps token buckets per client and route
+type RateLimiter struct {
+	userLimit config.RateLimit
+	ipLimit   config.RateLimit
+	overrides map[string]config.RateLimit
+
+	mutex       sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to burst tokens
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a rate limiter from the configuration
+func NewRateLimiter(cfg *config.Config) *RateLimiter {
+	log.Printf("Middleware: Creating rate limiter, user limit: %+v, IP limit: %+v, route overrides: %d",
+		cfg.RateLimit.User, cfg.RateLimit.IP, len(cfg.RateLimit.Routes))
+	return &RateLimiter{
+		userLimit:   cfg.RateLimit.User,
+		ipLimit:     cfg.RateLimit.IP,
+		overrides:   cfg.RateLimit.Routes,
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// RateLimitByIP creates a middleware that limits requests per client IP, for anonymous routes
+func RateLimitByIP(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter.limit(c, "ip:"+c.ClientIP(), limiter.ipLimit)
+	}
+}
+
+// RateLimitByUser creates a middleware that limits requests per authenticated user.
+// It must run after AuthMiddleware.
+func RateLimitByUser(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserID(c)
+		if err != nil {
+			limiter.limit(c, "ip:"+c.ClientIP(), limiter.ipLimit)
+			return
+		}
+		limiter.limit(c, "user:"+userID.String(), limiter.userLimit)
+	}
+}
+
+// limit takes a token from the client bucket, or aborts the request with 429 when it is empty.
+// Routes with an override get their own bucket per client.
+func (l *RateLimiter) limit(c *gin.Context, clientKey string, limit config.RateLimit) {
+	route := RouteKey(c.Request.Method, c.FullPath())
+	if override, exists := l.overrides[route]; exists {
+		limit = override
+		clientKey = clientKey + "|" + route
+	}
+
+	// A non-positive rate disables the limit
+	if limit.Rate <= 0 {
+		c.Next()
+		return
+	}
+
+	allowed, remaining, retryAfter := l.take(clientKey, limit)
+
+	// Reset is the time until the bucket is full again, the window is the time to refill it from empty
+	reset := secondsFor(float64(limit.Burst)-remaining, limit.Rate)
+	window := secondsFor(float64(limit.Burst), limit.Rate)
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	c.Header("RateLimit-Remaining", strconv.Itoa(max(int(math.Floor(remaining)), 0)))
+	c.Header("RateLimit-Reset", strconv.Itoa(reset))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Burst, window))
+
+	if !allowed {
+		log.Printf("Middleware: Rate limit exceeded for %s, path: %s", clientKey, c.Request.URL.Path)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+		return
+	}
+
+	c.Set(rateLimitChargeKey, rateLimitCharge{limiter: l, key: clientKey, limit: limit})
+	c.Next()
+}
+
+// ChargeRateLimit takes cost - 1 more tokens from the bucket that let the request through, for requests
+// that do the work of several, like a batch prediction per item. The bucket may go below zero: the request
+// is not rejected, but the next ones of the client are until the tokens are refilled.
+func ChargeRateLimit(c *gin.Context, cost int) {
+	value, exists := c.Get(rateLimitChargeKey)
+	if !exists || cost <= 1 {
+		return
+	}
+	charge := value.(rateLimitCharge)
+
+	remaining := charge.limiter.charge(charge.key, float64(cost-1))
+	c.Header("RateLimit-Remaining", strconv.Itoa(max(int(math.Floor(remaining)), 0)))
+	c.Header("RateLimit-Reset", strconv.Itoa(secondsFor(float64(charge.limit.Burst)-remaining, charge.limit.Rate)))
+}
+
+// take refills the bucket and takes one token from it. It returns whether the request is allowed,
+// the tokens left and, when not allowed, how long until a token is available.
+func (l *RateLimiter) take(key string, limit config.RateLimit) (bool, float64, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	// Refill the tokens accumulated since the last request
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+	bucket.updated = now
+
+	// Tokens below zero were charged by earlier requests and are refilled first
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+		return false, bucket.tokens, wait
+	}
+
+	bucket.tokens--
+	return true, bucket.tokens, 0
+}
+
+// charge takes tokens from a bucket, going below zero if needed, and returns the tokens left
+func (l *RateLimiter) charge(key string, tokens float64) float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		return 0
+	}
+	bucket.tokens -= tokens
+	return bucket.tokens
+}
+
+// cleanup drops the buckets that have not been used for a while
+func (l *RateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < idleBucketTTL {
+		return
+	}
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// secondsFor returns the whole seconds needed to refill the given tokens at rate tokens per second
+func secondsFor(tokens float64, rate float64) int {
+	return int(math.Ceil(tokens / rate))
+}
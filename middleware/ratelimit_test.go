@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+func TestRateLimitByIPTrustedProxies(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		want           []int
+	}{
+		{"untrusted forwarded IPs share the connection bucket", nil, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+		{"trusted proxy forwards client IPs", []string{"192.0.2.0/24"}, []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			if err := router.SetTrustedProxies(tt.trustedProxies); err != nil {
+				t.Fatalf("SetTrustedProxies: %v", err)
+			}
+			limiter := NewRateLimiter(&config.Config{RateLimit: config.RateLimitConfig{IP: config.RateLimit{Rate: 0.001, Burst: 1}}})
+			router.POST("/auth/login", RateLimitByIP(limiter), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, forwardedFor := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+				request := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+				request.Header.Set("X-Forwarded-For", forwardedFor)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, request)
+				if recorder.Code != tt.want[i] {
+					t.Errorf("request %d from %s: status = %d, want %d", i+1, forwardedFor, recorder.Code, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChargeRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := NewRateLimiter(&config.Config{RateLimit: config.RateLimitConfig{IP: config.RateLimit{Rate: 0.001, Burst: 10}}})
+	router.POST("/batch", RateLimitByIP(limiter), func(c *gin.Context) {
+		cost, _ := strconv.Atoi(c.Query("items"))
+		ChargeRateLimit(c, cost)
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		items         string
+		wantStatus    int
+		wantRemaining string
+	}{
+		{"4", http.StatusOK, "6"},
+		// A batch larger than the tokens left is served, the next requests wait for the refill
+		{"8", http.StatusOK, "0"},
+		{"1", http.StatusTooManyRequests, "0"},
+	}
+	for i, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/batch?items="+tt.items, nil))
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("request %d of %s items: status = %d, want %d", i+1, tt.items, recorder.Code, tt.wantStatus)
+		}
+		if remaining := recorder.Header().Get("RateLimit-Remaining"); remaining != tt.wantRemaining {
+			t.Errorf("request %d of %s items: RateLimit-Remaining = %s, want %s", i+1, tt.items, remaining, tt.wantRemaining)
+		}
+	}
+}
@@ -1,11 +1,30 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Identity headers forwarded to upstream services, copies sent by clients are dropped
+const (
+	HeaderUserID       = "X-User-ID"
+	HeaderUserRole     = "X-User-Role"
+	HeaderUserEmail    = "X-User-Email"
+	HeaderGatewayToken = "X-Gateway-Token"
+)
+
+// IdentityHeaders lists the headers that carry the caller identity to upstream services
+var IdentityHeaders = []string{HeaderUserID, HeaderUserRole, HeaderUserEmail, HeaderGatewayToken}
+
+// Identity represents the authenticated caller of a request
+type Identity struct {
+	UserID uuid.UUID
+	Email  string
+	Role   string
+}
+
 // PredictionHistory represents a saved prediction request and result
 type PredictionHistory struct {
 	ID           uuid.UUID         `json:"id" db:"id"`
@@ -59,9 +78,160 @@ type UserLoginResponse struct {
 	LastLoginAt  time.Time `json:"last_login_at"`
 }
 
+// RefreshTokenRequest represents a request to refresh an access token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenResponse represents a response to a token refresh request
+type RefreshTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// LogoutRequest represents a request to logout a user
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenRevocation represents a revocation of all tokens of a user
+type TokenRevocation struct {
+	UserID        uuid.UUID `json:"user_id"`
+	RevokedBefore time.Time `json:"revoked_before"`
+}
+
+// API key scopes, each grants access to a group of machine-client routes
+const (
+	ScopePredict    = "predict"
+	ScopeStatus     = "status"
+	ScopeStatistics = "statistics"
+)
+
+// APIKeyScopes lists the scopes an API key can be granted
+var APIKeyScopes = []string{ScopePredict, ScopeStatus, ScopeStatistics}
+
+// APIKey represents a gateway-managed API key of a machine client
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPIKeyResponse represents a created API key, the plain key is only returned here
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// LoginLockout represents the failed login state of an email or a client IP
+type LoginLockout struct {
+	Type          string     `json:"type"`
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// CircuitBreakerStatus represents the state and counters of an upstream circuit breaker
+type CircuitBreakerStatus struct {
+	State string `json:"state"`
+	// Requests and Failures count the calls of the current window
+	Requests int        `json:"requests"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	// The totals count since startup
+	SuccessesTotal uint64 `json:"successes_total"`
+	FailuresTotal  uint64 `json:"failures_total"`
+	RejectedTotal  uint64 `json:"rejected_total"`
+	OpenedTotal    uint64 `json:"opened_total"`
+}
+
+// InstanceStatus represents the load balancing state of an upstream service instance
+type InstanceStatus struct {
+	Address             string     `json:"address"`
+	Weight              int        `json:"weight"`
+	Outstanding         int        `json:"outstanding"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+}
+
+// UpstreamStatus represents the client-side state of an upstream service
+type UpstreamStatus struct {
+	Name           string               `json:"name"`
+	Strategy       string               `json:"strategy"`
+	CircuitBreaker CircuitBreakerStatus `json:"circuit_breaker"`
+	Bulkhead       *BulkheadStatus      `json:"bulkhead,omitempty"`
+	Hedging        *HedgingStatus       `json:"hedging,omitempty"`
+	Instances      []InstanceStatus     `json:"instances,omitempty"`
+}
+
+// HedgingStatus represents the hedged calls to an upstream service
+type HedgingStatus struct {
+	// DelaysMS maps the hedged operations to their current hedging delay, absent until enough latencies are known
+	DelaysMS    map[string]int64 `json:"delays_ms"`
+	HedgesTotal uint64           `json:"hedges_total"`
+	WinsTotal   uint64           `json:"wins_total"`
+}
+
+// BulkheadStatus represents the concurrency limit of the calls to an upstream service
+type BulkheadStatus struct {
+	MaxConcurrent int    `json:"max_concurrent"`
+	MaxQueued     int    `json:"max_queued"`
+	Active        int    `json:"active"`
+	Queued        int    `json:"queued"`
+	AdmittedTotal uint64 `json:"admitted_total"`
+	RejectedTotal uint64 `json:"rejected_total"`
+}
+
+// Dependency statuses
+const (
+	DependencyUp      = "up"
+	DependencyDown    = "down"
+	DependencyUnknown = "unknown"
+)
+
+// DependencyStatus represents the result of the latest probe of a dependency
+type DependencyStatus struct {
+	Name string `json:"name"`
+	// Required dependencies gate the readiness of the gateway
+	Required      bool       `json:"required"`
+	Status        string     `json:"status"`
+	LatencyMS     int64      `json:"latency_ms"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// HealthResponse represents the health of the gateway, its dependencies and upstream services
+type HealthResponse struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyStatus `json:"dependencies,omitempty"`
+	Upstreams    []UpstreamStatus   `json:"upstreams,omitempty"`
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
 // ML Service Models
@@ -115,6 +285,65 @@ type PredictionRequestMinimal struct {
 type PredictionResult struct {
 	PredictedPrice float64 `json:"predicted_price"`
 	PredictedSales float64 `json:"predicted_sales"`
+	// CacheStatus tells whether the result came from the response cache, empty when the cache is disabled
+	CacheStatus string `json:"-"`
+}
+
+// Prediction cache statuses, reported in the X-Cache header
+const (
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheBypass = "BYPASS"
+)
+
+// BatchPredictionRequest represents a request to make several predictions at once
+type BatchPredictionRequest struct {
+	// Minimal selects PredictionRequestMinimal items instead of PredictionRequest ones
+	Minimal bool              `json:"minimal"`
+	Items   []json.RawMessage `json:"items"`
+}
+
+// BatchPredictionItem represents the outcome of an item of a batch prediction
+type BatchPredictionItem struct {
+	Index  int               `json:"index"`
+	Status int               `json:"status"`
+	Result *PredictionResult `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	// Errors lists the invalid fields of an item that failed validation
+	Errors []FieldError `json:"errors,omitempty"`
+	// Err is the error of a failed item, its Status is derived from it
+	Err error `json:"-"`
+}
+
+// BatchPredictionResponse represents the outcome of every item of a batch prediction, in input order
+type BatchPredictionResponse struct {
+	Results   []BatchPredictionItem `json:"results"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+}
+
+// FieldError represents an invalid value of a request
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// LineError represents an invalid value of an uploaded CSV file, at a line of the file
+type LineError struct {
+	Line int `json:"line"`
+	FieldError
+}
+
+// ValidationErrorResponse represents an error response listing every invalid value of a request
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Errors []FieldError `json:"errors"`
+}
+
+// CSVErrorResponse represents an error response listing every invalid value of an uploaded CSV file
+type CSVErrorResponse struct {
+	Error  string      `json:"error"`
+	Errors []LineError `json:"errors"`
 }
 
 // TrainingResult represents a training result
@@ -129,6 +358,30 @@ type TrainingResult struct {
 	} `json:"sales_model"`
 }
 
+// Training job states
+const (
+	TrainingJobQueued    = "queued"
+	TrainingJobRunning   = "running"
+	TrainingJobSucceeded = "succeeded"
+	TrainingJobFailed    = "failed"
+)
+
+// TrainingJob represents a model training run in the background
+type TrainingJob struct {
+	ID         uuid.UUID       `json:"id"`
+	UserID     uuid.UUID       `json:"user_id"`
+	State      string          `json:"state"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Result     *TrainingResult `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	// Owner identifies the gateway instance running the job
+	Owner string `json:"owner,omitempty"`
+	// HeartbeatAt is when the owner last recorded that it is alive, the job is failed once it is stale
+	HeartbeatAt *time.Time `json:"heartbeat_at,omitempty"`
+}
+
 // ModelStatus represents the status of the prediction models
 type ModelStatus struct {
 	ModelsTrained bool `json:"models_trained"`
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// discountTolerance is how far, in percentage points, the discount may be from the one computed from the prices
+const discountTolerance = 1.0
+
+// Validate checks the required fields, the ranges of the values and their consistency,
+// it returns an error for every invalid field
+func (r *PredictionRequest) Validate() []FieldError {
+	var v fieldValidator
+	v.required("product_name", r.ProductName)
+	v.required("brand", r.Brand)
+	v.required("category", r.Category)
+	v.required("region", r.Region)
+	v.required("seller", r.Seller)
+
+	v.nonNegative("price", r.Price)
+	v.nonNegative("original_price", r.OriginalPrice)
+	v.between("discount_percentage", r.DiscountPercentage, 0, 100)
+	v.nonNegative("stock_level", r.StockLevel)
+	v.between("customer_rating", r.CustomerRating, 0, 5)
+	v.nonNegative("review_count", r.ReviewCount)
+	v.nonNegative("delivery_days", r.DeliveryDays)
+	v.between("day_of_week", float64(r.DayOfWeek), 0, 6)
+	v.between("month", float64(r.Month), 1, 12)
+	v.between("quarter", float64(r.Quarter), 1, 4)
+	v.nonNegative("sales_quantity_lag_1", r.SalesQuantityLag1)
+	v.nonNegative("price_lag_1", r.PriceLag1)
+	v.nonNegative("sales_quantity_lag_3", r.SalesQuantityLag3)
+	v.nonNegative("price_lag_3", r.PriceLag3)
+	v.nonNegative("sales_quantity_lag_7", r.SalesQuantityLag7)
+	v.nonNegative("price_lag_7", r.PriceLag7)
+	v.nonNegative("sales_quantity_rolling_mean_3", r.SalesQuantityRollingMean3)
+	v.nonNegative("price_rolling_mean_3", r.PriceRollingMean3)
+	v.nonNegative("sales_quantity_rolling_mean_7", r.SalesQuantityRollingMean7)
+	v.nonNegative("price_rolling_mean_7", r.PriceRollingMean7)
+
+	// Cross-field checks only run on values that are valid on their own
+	if v.valid("month") && v.valid("quarter") {
+		if quarter := (r.Month-1)/3 + 1; r.Quarter != quarter {
+			v.add("quarter", "must be %d for month %d", quarter, r.Month)
+		}
+	}
+	if v.valid("price") && v.valid("original_price") && v.valid("discount_percentage") {
+		switch {
+		case r.OriginalPrice == 0:
+			if r.DiscountPercentage != 0 {
+				v.add("discount_percentage", "requires original_price")
+			}
+		case r.Price > r.OriginalPrice:
+			v.add("price", "must not exceed original_price")
+		default:
+			discount := (r.OriginalPrice - r.Price) / r.OriginalPrice * 100
+			if math.Abs(discount-r.DiscountPercentage) > discountTolerance {
+				v.add("discount_percentage", "does not match price and original_price, expected %.2f", discount)
+			}
+		}
+	}
+
+	return v.errors
+}
+
+// Validate checks the required fields and the ranges of the optional values,
+// it returns an error for every invalid field
+func (r *PredictionRequestMinimal) Validate() []FieldError {
+	var v fieldValidator
+	v.required("product_name", r.ProductName)
+	v.required("region", r.Region)
+	v.required("seller", r.Seller)
+
+	if r.Price != nil {
+		v.nonNegative("price", *r.Price)
+	}
+	if r.OriginalPrice != nil {
+		v.nonNegative("original_price", *r.OriginalPrice)
+	}
+	if r.StockLevel != nil {
+		v.nonNegative("stock_level", *r.StockLevel)
+	}
+	if r.CustomerRating != nil {
+		v.between("customer_rating", *r.CustomerRating, 0, 5)
+	}
+	if r.ReviewCount != nil {
+		v.nonNegative("review_count", *r.ReviewCount)
+	}
+	if r.DeliveryDays != nil {
+		v.nonNegative("delivery_days", *r.DeliveryDays)
+	}
+
+	if r.Price != nil && r.OriginalPrice != nil && v.valid("price") && v.valid("original_price") && *r.Price > *r.OriginalPrice {
+		v.add("price", "must not exceed original_price")
+	}
+
+	return v.errors
+}
+
+// fieldValidator collects the errors of the fields of a request
+type fieldValidator struct {
+	errors []FieldError
+}
+
+// add records an error of a field
+func (v *fieldValidator) add(field, format string, args ...any) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// valid reports whether no error was recorded for a field
+func (v *fieldValidator) valid(field string) bool {
+	for _, fieldError := range v.errors {
+		if fieldError.Field == field {
+			return false
+		}
+	}
+	return true
+}
+
+// required checks that a string field is not blank
+func (v *fieldValidator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+	}
+}
+
+// nonNegative checks that a number field is finite and not negative
+func (v *fieldValidator) nonNegative(field string, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		v.add(field, "must be a non-negative number")
+	}
+}
+
+// between checks that a number field is within a range, bounds included
+func (v *fieldValidator) between(field string, value, min, max float64) {
+	if math.IsNaN(value) || value < min || value > max {
+		v.add(field, "must be between %g and %g", min, max)
+	}
+}
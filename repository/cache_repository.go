@@ -2,6 +2,7 @@ package repository
 
 import (
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/graduate-work-mirea/api-gateway/config"
@@ -14,12 +15,46 @@ type CacheRepository interface {
 	SavePrediction(userID uuid.UUID, prediction model.PredictionHistory) error
 	GetUserPredictions(userID uuid.UUID) ([]model.PredictionHistory, bool)
 	PopulateFromMap(predictions map[uuid.UUID][]model.PredictionHistory)
+
+	// Prediction responses
+	SavePredictionResult(key string, result model.PredictionResult)
+	GetPredictionResult(key string) (*model.PredictionResult, bool)
+	ClearPredictionResults()
+
+	// Token revocation
+	RevokeToken(tokenID string, expiresAt time.Time)
+	RevokeUserTokens(userID uuid.UUID, revokedBefore time.Time)
+	IsTokenRevoked(tokenID string, userID uuid.UUID, issuedAt time.Time) bool
+	PopulateRevocations(tokens map[string]time.Time, users map[uuid.UUID]time.Time)
+
+	// API keys
+	SaveAPIKey(keyHash string, apiKey *model.APIKey)
+	GetAPIKey(keyHash string) (*model.APIKey, bool)
+	DeleteAPIKey(keyID uuid.UUID)
+}
+
+// cachedAPIKey is an API key looked up in the database, kept until expiresAt
+type cachedAPIKey struct {
+	apiKey    *model.APIKey
+	expiresAt time.Time
+}
+
+// cachedPredictionResult is a prediction response of the ML service, kept until expiresAt
+type cachedPredictionResult struct {
+	result    model.PredictionResult
+	expiresAt time.Time
 }
 
 type lruCacheRepository struct {
-	cache     *lru.Cache
-	userCache map[uuid.UUID][]model.PredictionHistory
-	mutex     sync.RWMutex
+	// cache holds the prediction responses by request key
+	cache         *lru.Cache
+	resultTTL     time.Duration
+	userCache     map[uuid.UUID][]model.PredictionHistory
+	revokedTokens map[string]time.Time
+	revokedUsers  map[uuid.UUID]time.Time
+	apiKeys       map[string]cachedAPIKey
+	apiKeyTTL     time.Duration
+	mutex         sync.RWMutex
 }
 
 // NewCacheRepository creates a new cache repository
@@ -31,9 +66,14 @@ func NewCacheRepository(cfg *config.Config) (CacheRepository, error) {
 	}
 
 	return &lruCacheRepository{
-		cache:     cache,
-		userCache: make(map[uuid.UUID][]model.PredictionHistory),
-		mutex:     sync.RWMutex{},
+		cache:         cache,
+		resultTTL:     cfg.PredictionCacheTTL,
+		userCache:     make(map[uuid.UUID][]model.PredictionHistory),
+		revokedTokens: make(map[string]time.Time),
+		revokedUsers:  make(map[uuid.UUID]time.Time),
+		apiKeys:       make(map[string]cachedAPIKey),
+		apiKeyTTL:     cfg.APIKeyCacheTTL,
+		mutex:         sync.RWMutex{},
 	}, nil
 }
 
@@ -57,9 +97,6 @@ func (r *lruCacheRepository) SavePrediction(userID uuid.UUID, prediction model.P
 	predictions = append([]model.PredictionHistory{prediction}, predictions...)
 	r.userCache[userID] = predictions
 
-	// Also store in general LRU cache by prediction ID
-	r.cache.Add(prediction.ID, prediction)
-
 	return nil
 }
 
@@ -95,10 +132,123 @@ func (r *lruCacheRepository) PopulateFromMap(predictions map[uuid.UUID][]model.P
 	// Populate cache from map
 	for userID, userPredictions := range predictions {
 		r.userCache[userID] = userPredictions
+	}
+}
+
+// SavePredictionResult caches a prediction response by the key of its request for the configured TTL,
+// the least recently used responses are evicted once the cache is full
+func (r *lruCacheRepository) SavePredictionResult(key string, result model.PredictionResult) {
+	if r.resultTTL <= 0 {
+		return
+	}
+	r.cache.Add(key, cachedPredictionResult{result: result, expiresAt: time.Now().Add(r.resultTTL)})
+}
+
+// GetPredictionResult retrieves a cached prediction response by the key of its request
+func (r *lruCacheRepository) GetPredictionResult(key string) (*model.PredictionResult, bool) {
+	value, exists := r.cache.Get(key)
+	if !exists {
+		return nil, false
+	}
+
+	cached := value.(cachedPredictionResult)
+	if time.Now().After(cached.expiresAt) {
+		r.cache.Remove(key)
+		return nil, false
+	}
+	result := cached.result
+	return &result, true
+}
+
+// ClearPredictionResults drops every cached prediction response
+func (r *lruCacheRepository) ClearPredictionResults() {
+	r.cache.Purge()
+}
+
+// RevokeToken marks an access token as revoked until it expires
+func (r *lruCacheRepository) RevokeToken(tokenID string, expiresAt time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Drop entries for tokens that have expired on their own
+	now := time.Now()
+	for id, exp := range r.revokedTokens {
+		if now.After(exp) {
+			delete(r.revokedTokens, id)
+		}
+	}
+
+	r.revokedTokens[tokenID] = expiresAt
+}
+
+// RevokeUserTokens marks every token of a user issued before the given time as revoked
+func (r *lruCacheRepository) RevokeUserTokens(userID uuid.UUID, revokedBefore time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.revokedUsers[userID] = revokedBefore
+}
+
+// IsTokenRevoked checks whether an access token has been revoked, either on its own
+// or by a revocation of all tokens of its user
+func (r *lruCacheRepository) IsTokenRevoked(tokenID string, userID uuid.UUID, issuedAt time.Time) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if _, revoked := r.revokedTokens[tokenID]; revoked {
+		return true
+	}
+
+	// iat has second precision, so a token issued in the same second as the revocation is revoked too
+	revokedBefore, exists := r.revokedUsers[userID]
+	return exists && !issuedAt.After(revokedBefore.Truncate(time.Second))
+}
+
+// PopulateRevocations replaces the revocation state with the given tokens and users
+func (r *lruCacheRepository) PopulateRevocations(tokens map[string]time.Time, users map[uuid.UUID]time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.revokedTokens = tokens
+	r.revokedUsers = users
+}
+
+// SaveAPIKey caches an API key by the hash of its plain value
+func (r *lruCacheRepository) SaveAPIKey(keyHash string, apiKey *model.APIKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Drop expired entries so keys that are no longer used do not pile up
+	now := time.Now()
+	for hash, cached := range r.apiKeys {
+		if now.After(cached.expiresAt) {
+			delete(r.apiKeys, hash)
+		}
+	}
+
+	r.apiKeys[keyHash] = cachedAPIKey{apiKey: apiKey, expiresAt: now.Add(r.apiKeyTTL)}
+}
+
+// GetAPIKey retrieves a cached API key by the hash of its plain value
+func (r *lruCacheRepository) GetAPIKey(keyHash string) (*model.APIKey, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	cached, exists := r.apiKeys[keyHash]
+	if !exists || time.Now().After(cached.expiresAt) {
+		return nil, false
+	}
+	return cached.apiKey, true
+}
+
+// DeleteAPIKey removes an API key from the cache
+func (r *lruCacheRepository) DeleteAPIKey(keyID uuid.UUID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-		// Also add each prediction to the LRU cache
-		for _, prediction := range userPredictions {
-			r.cache.Add(prediction.ID, prediction)
+	for hash, cached := range r.apiKeys {
+		if cached.apiKey.ID == keyID {
+			delete(r.apiKeys, hash)
 		}
 	}
 }
@@ -1,8 +1,10 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -10,14 +12,46 @@ import (
 	"github.com/google/uuid"
 	"github.com/graduate-work-mirea/api-gateway/config"
 	"github.com/graduate-work-mirea/api-gateway/model"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrTrainingJobActive is returned when a training job is saved as active while another one is
+var ErrTrainingJobActive = errors.New("another training job is active")
+
+// singleActiveTrainingJobIndex allows a single queued or running training job over all gateway instances
+const singleActiveTrainingJobIndex = "training_jobs_single_active"
+
 // DBRepository represents a PostgreSQL repository
 type DBRepository interface {
-	SavePrediction(userID uuid.UUID, request interface{}, result *model.PredictionResult, minimal bool) error
-	GetUserPredictions(userID uuid.UUID) ([]model.PredictionHistory, error)
-	GetAllPredictions() (map[uuid.UUID][]model.PredictionHistory, error)
+	SavePrediction(ctx context.Context, userID uuid.UUID, request interface{}, result *model.PredictionResult, minimal bool) error
+	GetUserPredictions(ctx context.Context, userID uuid.UUID) ([]model.PredictionHistory, error)
+	GetAllPredictions(ctx context.Context) (map[uuid.UUID][]model.PredictionHistory, error)
+
+	// Token revocation
+	RevokeToken(ctx context.Context, tokenID string, userID uuid.UUID, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedBefore time.Time) error
+	GetRevokedTokens(ctx context.Context) (map[string]time.Time, error)
+	GetUserTokenRevocations(ctx context.Context) (map[uuid.UUID]time.Time, error)
+
+	// API keys
+	CreateAPIKey(ctx context.Context, apiKey *model.APIKey, keyHash string) error
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*model.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID uuid.UUID, keyID uuid.UUID) error
+	TouchAPIKey(ctx context.Context, keyID uuid.UUID, usedAt time.Time) error
+
+	// Training jobs
+	SaveTrainingJob(ctx context.Context, job *model.TrainingJob) error
+	GetTrainingJob(ctx context.Context, jobID uuid.UUID) (*model.TrainingJob, error)
+	ListTrainingJobs(ctx context.Context, limit int) ([]model.TrainingJob, error)
+	GetLastSucceededTrainingJob(ctx context.Context) (*model.TrainingJob, error)
+	TouchTrainingJob(ctx context.Context, jobID uuid.UUID, owner string, heartbeatAt time.Time) error
+	FailStaleTrainingJobs(ctx context.Context, staleBefore time.Time, reason string) (int64, error)
+
+	Ping(ctx context.Context) error
 	Close() error
 }
 
@@ -47,6 +81,11 @@ func NewPostgreRepository(cfg *config.Config) (DBRepository, error) {
 		return nil, err
 	}
 
+	// Bring tables created by older versions up to date
+	if err := migrateTables(db); err != nil {
+		return nil, err
+	}
+
 	return &postgreRepository{db: db}, nil
 }
 
@@ -69,11 +108,139 @@ func createTables(db *sql.DB) error {
 		return err
 	}
 
+	// Create revoked tokens table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			token_id VARCHAR(128) PRIMARY KEY,
+			user_id UUID NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create per-user revocations table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_token_revocations (
+			user_id UUID PRIMARY KEY,
+			revoked_before TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create API keys table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) NOT NULL UNIQUE,
+			scopes JSONB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create training jobs table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS training_jobs (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL,
+			state VARCHAR(16) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			result JSONB,
+			error TEXT,
+			owner TEXT,
+			heartbeat_at TIMESTAMPTZ,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// migrateTables updates the columns of tables created by older versions of the gateway
+func migrateTables(db *sql.DB) error {
+	// Revocation times were stored without a time zone, as the wall clock of the gateway host
+	for _, column := range []struct{ table, name string }{
+		{"revoked_tokens", "expires_at"},
+		{"user_token_revocations", "revoked_before"},
+	} {
+		if err := convertToTimestamptz(db, column.table, column.name); err != nil {
+			return fmt.Errorf("migrate %s.%s: %w", column.table, column.name, err)
+		}
+	}
+
+	// Training jobs record the gateway instance running them and its heartbeats
+	_, err := db.Exec(`
+		ALTER TABLE training_jobs
+			ADD COLUMN IF NOT EXISTS owner TEXT,
+			ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMPTZ
+	`)
+	if err != nil {
+		return fmt.Errorf("migrate training_jobs: %w", err)
+	}
+
+	// Unfinished jobs without heartbeats were left by older versions, which failed them on every restart
+	_, err = db.Exec(`
+		UPDATE training_jobs SET state = $1, finished_at = $2, error = $3
+		WHERE state IN ($4, $5) AND heartbeat_at IS NULL
+	`, model.TrainingJobFailed, time.Now(), "interrupted by gateway upgrade", model.TrainingJobQueued, model.TrainingJobRunning)
+	if err != nil {
+		return fmt.Errorf("migrate training_jobs: %w", err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`
+		CREATE UNIQUE INDEX IF NOT EXISTS %s ON training_jobs ((true)) WHERE state IN ('%s', '%s')
+	`, singleActiveTrainingJobIndex, model.TrainingJobQueued, model.TrainingJobRunning))
+	if err != nil {
+		return fmt.Errorf("migrate training_jobs: %w", err)
+	}
+
+	return nil
+}
+
+// convertToTimestamptz converts a TIMESTAMP column to TIMESTAMPTZ, columns that already have a time zone
+// are left as they are. The wall clock times are read in the time zone of the database session, which
+// PGTZ sets to the one of the gateway host, so every row gets the UTC offset in effect when it was written.
+func convertToTimestamptz(db *sql.DB, table, column string) error {
+	var dataType string
+	err := db.QueryRow(`
+		SELECT data_type
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+	`, table, column).Scan(&dataType)
+	if err != nil {
+		return err
+	}
+	if dataType != "timestamp without time zone" {
+		return nil
+	}
+
+	log.Printf("Converting %s.%s to TIMESTAMPTZ", table, column)
+	_, err = db.Exec(fmt.Sprintf(
+		`ALTER TABLE %s ALTER COLUMN %s TYPE TIMESTAMPTZ USING %s AT TIME ZONE current_setting('TimeZone')`,
+		table, column, column))
+	return err
+}
+
 // SavePrediction saves a prediction request and result to the database
-func (r *postgreRepository) SavePrediction(userID uuid.UUID, request interface{}, result *model.PredictionResult, minimal bool) error {
+func (r *postgreRepository) SavePrediction(ctx context.Context, userID uuid.UUID, request interface{}, result *model.PredictionResult, minimal bool) error {
 	// Skip saving if both predicted values are 0
 	if result.PredictedPrice == 0 && result.PredictedSales == 0 {
 		return nil
@@ -96,7 +263,7 @@ func (r *postgreRepository) SavePrediction(userID uuid.UUID, request interface{}
 	}
 
 	// Insert prediction history
-	_, err = r.db.Exec(`
+	_, err = r.db.ExecContext(ctx, `
 		INSERT INTO prediction_history (user_id, request, result, endpoint_type, minimal, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
 	`, userID, requestJSON, resultJSON, endpointType, minimal, time.Now())
@@ -109,8 +276,8 @@ func (r *postgreRepository) SavePrediction(userID uuid.UUID, request interface{}
 }
 
 // GetUserPredictions retrieves all predictions for a user
-func (r *postgreRepository) GetUserPredictions(userID uuid.UUID) ([]model.PredictionHistory, error) {
-	rows, err := r.db.Query(`
+func (r *postgreRepository) GetUserPredictions(ctx context.Context, userID uuid.UUID) ([]model.PredictionHistory, error) {
+	rows, err := r.db.QueryContext(ctx, `
 		SELECT id, user_id, request, result, created_at, endpoint_type, minimal
 		FROM prediction_history
 		WHERE user_id = $1 
@@ -168,8 +335,8 @@ func (r *postgreRepository) GetUserPredictions(userID uuid.UUID) ([]model.Predic
 }
 
 // GetAllPredictions retrieves all predictions from the database grouped by user
-func (r *postgreRepository) GetAllPredictions() (map[uuid.UUID][]model.PredictionHistory, error) {
-	rows, err := r.db.Query(`
+func (r *postgreRepository) GetAllPredictions(ctx context.Context) (map[uuid.UUID][]model.PredictionHistory, error) {
+	rows, err := r.db.QueryContext(ctx, `
 		SELECT id, user_id, request, result, created_at, endpoint_type, minimal
 		FROM prediction_history
 		WHERE (result->>'predicted_price' != '0' OR result->>'predicted_sales' != '0')
@@ -229,6 +396,405 @@ func (r *postgreRepository) GetAllPredictions() (map[uuid.UUID][]model.Predictio
 	return predictions, nil
 }
 
+// RevokeToken stores a revoked access token until it expires
+func (r *postgreRepository) RevokeToken(ctx context.Context, tokenID string, userID uuid.UUID, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO revoked_tokens (token_id, user_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (token_id) DO NOTHING
+	`, tokenID, userID, expiresAt, time.Now())
+	if err != nil {
+		log.Printf("Error revoking token: %v", err)
+		return err
+	}
+
+	// Expired tokens are rejected anyway, so their revocations can go
+	_, err = r.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < $1`, time.Now())
+	if err != nil {
+		log.Printf("Error deleting expired revoked tokens: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes every token of a user issued before the given time
+func (r *postgreRepository) RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedBefore time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO user_token_revocations (user_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before
+	`, userID, revokedBefore)
+	if err != nil {
+		log.Printf("Error revoking user tokens: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetRevokedTokens retrieves the revoked tokens that have not expired yet
+func (r *postgreRepository) GetRevokedTokens(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT token_id, expires_at
+		FROM revoked_tokens
+		WHERE expires_at >= $1
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make(map[string]time.Time)
+	for rows.Next() {
+		var tokenID string
+		var expiresAt time.Time
+		if err := rows.Scan(&tokenID, &expiresAt); err != nil {
+			return nil, err
+		}
+		tokens[tokenID] = expiresAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetUserTokenRevocations retrieves the per-user revocation times
+func (r *postgreRepository) GetUserTokenRevocations(ctx context.Context) (map[uuid.UUID]time.Time, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id, revoked_before
+		FROM user_token_revocations
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := make(map[uuid.UUID]time.Time)
+	for rows.Next() {
+		var userID uuid.UUID
+		var revokedBefore time.Time
+		if err := rows.Scan(&userID, &revokedBefore); err != nil {
+			return nil, err
+		}
+		revocations[userID] = revokedBefore
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// CreateAPIKey saves a new API key with the hash of its plain value
+func (r *postgreRepository) CreateAPIKey(ctx context.Context, apiKey *model.APIKey, keyHash string) error {
+	scopesJSON, err := json.Marshal(apiKey.Scopes)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, apiKey.ID, apiKey.UserID, apiKey.Name, apiKey.Prefix, keyHash, scopesJSON, apiKey.CreatedAt)
+	if err != nil {
+		log.Printf("Error saving API key: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetAPIKeyByHash retrieves an active API key by the hash of its plain value
+func (r *postgreRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`, keyHash)
+
+	apiKey, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return apiKey, err
+}
+
+// ListAPIKeys retrieves all API keys of a user, including revoked ones
+func (r *postgreRepository) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []model.APIKey{}
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, *apiKey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes an API key of a user
+func (r *postgreRepository) RevokeAPIKey(ctx context.Context, userID uuid.UUID, keyID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`, time.Now(), keyID, userID)
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIKey records the last time an API key was used
+func (r *postgreRepository) TouchAPIKey(ctx context.Context, keyID uuid.UUID, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, usedAt, keyID)
+	return err
+}
+
+// scanAPIKey scans an API key from a row of the api_keys table
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (*model.APIKey, error) {
+	var apiKey model.APIKey
+	var scopesJSON []byte
+	var lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&apiKey.ID,
+		&apiKey.UserID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&scopesJSON,
+		&apiKey.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(scopesJSON, &apiKey.Scopes); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		apiKey.RevokedAt = &revokedAt.Time
+	}
+
+	return &apiKey, nil
+}
+
+// SaveTrainingJob inserts a training job or updates its state. It returns ErrTrainingJobActive
+// when the job is queued or running while another one is.
+func (r *postgreRepository) SaveTrainingJob(ctx context.Context, job *model.TrainingJob) error {
+	var resultJSON []byte
+	if job.Result != nil {
+		var err error
+		resultJSON, err = json.Marshal(job.Result)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO training_jobs (id, user_id, state, created_at, started_at, finished_at, result, error, owner, heartbeat_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET
+			state = EXCLUDED.state,
+			started_at = EXCLUDED.started_at,
+			finished_at = EXCLUDED.finished_at,
+			result = EXCLUDED.result,
+			error = EXCLUDED.error,
+			owner = EXCLUDED.owner,
+			heartbeat_at = EXCLUDED.heartbeat_at
+	`, job.ID, job.UserID, job.State, job.CreatedAt, job.StartedAt, job.FinishedAt, resultJSON, sql.NullString{String: job.Error, Valid: job.Error != ""},
+		sql.NullString{String: job.Owner, Valid: job.Owner != ""}, job.HeartbeatAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == singleActiveTrainingJobIndex {
+		return ErrTrainingJobActive
+	}
+	if err != nil {
+		log.Printf("Error saving training job: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetTrainingJob gets a training job by ID
+func (r *postgreRepository) GetTrainingJob(ctx context.Context, jobID uuid.UUID) (*model.TrainingJob, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, state, created_at, started_at, finished_at, result, error, owner, heartbeat_at
+		FROM training_jobs
+		WHERE id = $1
+	`, jobID)
+
+	job, err := scanTrainingJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// ListTrainingJobs lists the most recent training jobs
+func (r *postgreRepository) ListTrainingJobs(ctx context.Context, limit int) ([]model.TrainingJob, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, state, created_at, started_at, finished_at, result, error, owner, heartbeat_at
+		FROM training_jobs
+		ORDER BY created_at DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []model.TrainingJob{}
+	for rows.Next() {
+		job, err := scanTrainingJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// GetLastSucceededTrainingJob gets the training job that trained the current models
+func (r *postgreRepository) GetLastSucceededTrainingJob(ctx context.Context) (*model.TrainingJob, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, state, created_at, started_at, finished_at, result, error, owner, heartbeat_at
+		FROM training_jobs
+		WHERE state = $1
+		ORDER BY finished_at DESC
+		LIMIT 1
+	`, model.TrainingJobSucceeded)
+
+	job, err := scanTrainingJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// TouchTrainingJob records a heartbeat of the owner of an unfinished training job. It returns
+// ErrNotFound when the job is finished or owned by another instance.
+func (r *postgreRepository) TouchTrainingJob(ctx context.Context, jobID uuid.UUID, owner string, heartbeatAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE training_jobs SET heartbeat_at = $1
+		WHERE id = $2 AND owner = $3 AND state IN ($4, $5)
+	`, heartbeatAt, jobID, owner, model.TrainingJobQueued, model.TrainingJobRunning)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FailStaleTrainingJobs marks the queued and running training jobs without a heartbeat since staleBefore
+// as failed, it returns their number
+func (r *postgreRepository) FailStaleTrainingJobs(ctx context.Context, staleBefore time.Time, reason string) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE training_jobs SET state = $1, finished_at = $2, error = $3
+		WHERE state IN ($4, $5) AND (heartbeat_at IS NULL OR heartbeat_at < $6)
+	`, model.TrainingJobFailed, time.Now(), reason, model.TrainingJobQueued, model.TrainingJobRunning, staleBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// scanTrainingJob scans a training job row
+func scanTrainingJob(row interface{ Scan(dest ...any) error }) (*model.TrainingJob, error) {
+	var job model.TrainingJob
+	var startedAt, finishedAt, heartbeatAt sql.NullTime
+	var resultJSON []byte
+	var jobError, owner sql.NullString
+
+	err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.State,
+		&job.CreatedAt,
+		&startedAt,
+		&finishedAt,
+		&resultJSON,
+		&jobError,
+		&owner,
+		&heartbeatAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if resultJSON != nil {
+		job.Result = &model.TrainingResult{}
+		if err := json.Unmarshal(resultJSON, job.Result); err != nil {
+			return nil, err
+		}
+	}
+	job.Error = jobError.String
+	job.Owner = owner.String
+	if heartbeatAt.Valid {
+		job.HeartbeatAt = &heartbeatAt.Time
+	}
+
+	return &job, nil
+}
+
+// Ping checks that the database is reachable
+func (r *postgreRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
 // Close closes the database connection
 func (r *postgreRepository) Close() error {
 	return r.db.Close()
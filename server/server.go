@@ -13,66 +13,75 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
-	router     *gin.Engine
-	config     *config.Config
-	controller *controller.Controller
+	router      *gin.Engine
+	config      *config.Config
+	controller  *controller.Controller
+	keys        *middleware.KeySet
+	credentials middleware.CredentialStore
 }
 
 // NewServer creates a new server
-func NewServer(cfg *config.Config, controller *controller.Controller, router *gin.Engine) *Server {
+func NewServer(cfg *config.Config, controller *controller.Controller, keys *middleware.KeySet, credentials middleware.CredentialStore, router *gin.Engine) *Server {
 	log.Println("Server: Configuring router with middleware...")
 
 	// Add recovery middleware
 	router.Use(gin.Recovery())
 
+	// Drop identity headers sent by clients, upstream services must only see the ones set by the gateway
+	router.Use(middleware.StripIdentityHeaders())
+
 	// Configure CORS middleware
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{cfg.CorsOrigin}
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", middleware.APIKeyHeader, "Cache-Control"}
+	corsConfig.ExposeHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "X-Cache"}
 	router.Use(cors.New(corsConfig))
 
 	log.Println("Server: CORS configured with origins:", cfg.CorsOrigin)
 
 	return &Server{
-		router:     router,
-		config:     cfg,
-		controller: controller,
+		router:      router,
+		config:      cfg,
+		controller:  controller,
+		keys:        keys,
+		credentials: credentials,
 	}
 }
 
 // SetupRoutes sets up all routes
-func (s *Server) SetupRoutes() {
+func (s *Server) SetupRoutes() error {
 	log.Println("Server: Setting up routes...")
 
 	// Create auth middleware
-	authMiddleware := middleware.AuthMiddleware(s.config)
+	authMiddleware := middleware.AuthMiddleware(s.config, s.keys, s.credentials)
 	log.Println("Server: Auth middleware created")
 
+	// Create rate limiter
+	rateLimiter := middleware.NewRateLimiter(s.config)
+	log.Println("Server: Rate limiter created")
+
 	// Register routes
-	s.controller.RegisterRoutes(authMiddleware)
+	if err := s.controller.RegisterRoutes(authMiddleware, rateLimiter, s.config.ProxyRoutes); err != nil {
+		return err
+	}
 	log.Println("Server: Controller routes registered")
 
-	// Add a health check endpoint
-	s.router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status": "ok",
-		})
-	})
-	log.Println("Server: Health check endpoint added")
-
 	// Log all registered routes
 	routes := s.router.Routes()
 	log.Println("Server: Registered routes:")
 	for _, route := range routes {
 		log.Printf("  %s %s", route.Method, route.Path)
 	}
+	return nil
 }
 
 // Start starts the server
 func (s *Server) Start() error {
 	// Set up routes
-	s.SetupRoutes()
+	if err := s.SetupRoutes(); err != nil {
+		return fmt.Errorf("set up routes: %w", err)
+	}
 
 	// Start the server
 	addr := fmt.Sprintf(":%s", s.config.Server.Port)
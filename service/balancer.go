@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// instance is a single endpoint of an upstream service
+type instance struct {
+	address string
+	weight  int
+
+	// The fields below are guarded by the balancer mutex
+	outstanding         int
+	currentWeight       int
+	consecutiveFailures int
+	ejectedUntil        time.Time
+}
+
+// url builds the URL of an endpoint of the instance
+func (i *instance) url(path string) string {
+	return fmt.Sprintf("http://%s%s", i.address, path)
+}
+
+// balancer spreads the calls to an upstream service over its instances and ejects
+// the instances that keep failing for a while
+type balancer struct {
+	name      string
+	strategy  string
+	ejection  config.EjectionConfig
+	instances []*instance
+
+	mutex sync.Mutex
+	next  int
+}
+
+// newBalancer creates a balancer over the endpoints of a service
+func newBalancer(name string, cfg config.ServiceConfig, ejection config.EjectionConfig) *balancer {
+	b := &balancer{
+		name:     name,
+		strategy: cfg.Strategy,
+		ejection: ejection,
+	}
+	for _, endpoint := range cfg.Endpoints {
+		b.instances = append(b.instances, &instance{
+			address: endpoint.Host + ":" + endpoint.Port,
+			weight:  max(endpoint.Weight, 1),
+		})
+	}
+	log.Printf("Service: %s balancer created with %d instances, strategy: %s", name, len(b.instances), b.strategy)
+	return b
+}
+
+// pick selects the instance for the next call and counts the call as outstanding, avoiding the
+// excluded instances unless no other one is left. Every picked instance has to be given back with done.
+func (b *balancer) pick(exclude ...*instance) *instance {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	candidates := make([]*instance, 0, len(b.instances))
+	for _, inst := range b.instances {
+		if !now.Before(inst.ejectedUntil) {
+			candidates = append(candidates, inst)
+		}
+	}
+	// With every instance ejected, failing somewhere beats not calling at all
+	if len(candidates) == 0 {
+		candidates = b.instances
+	}
+	if included := slices.DeleteFunc(slices.Clone(candidates), func(inst *instance) bool {
+		return slices.Contains(exclude, inst)
+	}); len(included) > 0 {
+		candidates = included
+	}
+
+	var picked *instance
+	switch b.strategy {
+	case config.StrategyLeastOutstanding:
+		// Ties are broken in round-robin order so idle instances share the load
+		start := b.next
+		b.next++
+		for i := range candidates {
+			inst := candidates[(start+i)%len(candidates)]
+			if picked == nil || inst.outstanding < picked.outstanding {
+				picked = inst
+			}
+		}
+	case config.StrategyWeighted:
+		// Smooth weighted round-robin, which interleaves the instances instead of sending bursts
+		total := 0
+		for _, inst := range candidates {
+			inst.currentWeight += inst.weight
+			total += inst.weight
+			if picked == nil || inst.currentWeight > picked.currentWeight {
+				picked = inst
+			}
+		}
+		picked.currentWeight -= total
+	default:
+		picked = candidates[b.next%len(candidates)]
+		b.next++
+	}
+
+	picked.outstanding++
+	return picked
+}
+
+// done gives back a picked instance. Counted outcomes update its failure streak,
+// a call abandoned by the caller is not counted.
+func (b *balancer) done(inst *instance, counted, success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	inst.outstanding--
+	if !counted {
+		return
+	}
+
+	if success {
+		inst.consecutiveFailures = 0
+		return
+	}
+
+	inst.consecutiveFailures++
+	if b.ejection.ConsecutiveFailures > 0 && inst.consecutiveFailures >= b.ejection.ConsecutiveFailures {
+		inst.ejectedUntil = time.Now().Add(b.ejection.Duration)
+		inst.consecutiveFailures = 0
+		log.Printf("Service: Ejecting %s instance %s until %v", b.name, inst.address, inst.ejectedUntil)
+	}
+}
+
+// status returns the state of every instance
+func (b *balancer) status() []model.InstanceStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	statuses := make([]model.InstanceStatus, 0, len(b.instances))
+	for _, inst := range b.instances {
+		status := model.InstanceStatus{
+			Address:             inst.address,
+			Weight:              inst.weight,
+			Outstanding:         inst.outstanding,
+			ConsecutiveFailures: inst.consecutiveFailures,
+		}
+		if now.Before(inst.ejectedUntil) {
+			ejectedUntil := inst.ejectedUntil
+			status.EjectedUntil = &ejectedUntil
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
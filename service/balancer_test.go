@@ -0,0 +1,144 @@
+package service
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+// testBalancer creates a balancer over instances a, b and c with the strategy and weights
+func testBalancer(strategy string, weights ...int) *balancer {
+	cfg := config.ServiceConfig{Strategy: strategy}
+	for i, host := range []string{"a", "b", "c"} {
+		endpoint := config.Endpoint{Host: host, Port: "80"}
+		if i < len(weights) {
+			endpoint.Weight = weights[i]
+		}
+		cfg.Endpoints = append(cfg.Endpoints, endpoint)
+	}
+	return newBalancer("ML", cfg, config.EjectionConfig{ConsecutiveFailures: 2, Duration: time.Minute})
+}
+
+// pickCounts picks and gives back n instances, counting the picks per address
+func pickCounts(b *balancer, n int) map[string]int {
+	counts := map[string]int{}
+	for range n {
+		inst := b.pick()
+		counts[inst.address]++
+		b.done(inst, true, true)
+	}
+	return counts
+}
+
+// callOn makes a call on an instance without picking it and gives it back with the outcome
+func callOn(b *balancer, inst *instance, counted, success bool) {
+	b.mutex.Lock()
+	inst.outstanding++
+	b.mutex.Unlock()
+	b.done(inst, counted, success)
+}
+
+func TestBalancerStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		balancer *balancer
+		want     map[string]int
+	}{
+		{"round robin", testBalancer(config.StrategyRoundRobin), map[string]int{"a:80": 4, "b:80": 4, "c:80": 4}},
+		{"least outstanding", testBalancer(config.StrategyLeastOutstanding), map[string]int{"a:80": 4, "b:80": 4, "c:80": 4}},
+		{"weighted", testBalancer(config.StrategyWeighted, 2, 1, 1), map[string]int{"a:80": 6, "b:80": 3, "c:80": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickCounts(tt.balancer, 12); !maps.Equal(got, tt.want) {
+				t.Errorf("picks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalancerLeastOutstandingAvoidsBusyInstances(t *testing.T) {
+	b := testBalancer(config.StrategyLeastOutstanding)
+	busy := b.pick()
+	other := b.pick()
+	if other == busy {
+		t.Fatal("second call picked the busy instance")
+	}
+	b.done(other, true, true)
+
+	for range 4 {
+		inst := b.pick()
+		if inst == busy {
+			t.Fatalf("picked %s with a call outstanding while others are idle", inst.address)
+		}
+		b.done(inst, true, true)
+	}
+}
+
+func TestBalancerEjection(t *testing.T) {
+	b := testBalancer(config.StrategyRoundRobin)
+	failing := b.instances[0]
+
+	// A success resets the failure streak
+	callOn(b, failing, true, false)
+	callOn(b, failing, true, true)
+	if b.status()[0].EjectedUntil != nil {
+		t.Fatal("instance ejected after failures interrupted by a success")
+	}
+
+	// Abandoned calls are not counted
+	callOn(b, failing, false, false)
+	callOn(b, failing, true, false)
+	if b.status()[0].EjectedUntil != nil {
+		t.Fatal("instance ejected counting an abandoned call")
+	}
+
+	// Consecutive failures eject the instance, which then gets no calls
+	callOn(b, failing, true, false)
+	if b.status()[0].EjectedUntil == nil {
+		t.Fatal("instance not ejected after consecutive failures")
+	}
+	if counts := pickCounts(b, 6); counts[failing.address] != 0 {
+		t.Errorf("ejected instance picked %d times", counts[failing.address])
+	}
+
+	// Once the ejection is over it gets calls again
+	b.mutex.Lock()
+	failing.ejectedUntil = time.Now()
+	b.mutex.Unlock()
+	if counts := pickCounts(b, 6); counts[failing.address] == 0 {
+		t.Error("instance not picked after its ejection ended")
+	}
+	if outstanding := b.status()[0].Outstanding; outstanding != 0 {
+		t.Errorf("outstanding calls = %d, want 0", outstanding)
+	}
+}
+
+func TestBalancerFallsBackWhenEveryInstanceIsEjectedOrExcluded(t *testing.T) {
+	b := testBalancer(config.StrategyRoundRobin)
+
+	// Excluded instances are avoided while another one is left
+	tried := b.pick()
+	for range 4 {
+		inst := b.pick(tried)
+		if inst == tried {
+			t.Fatal("excluded instance picked while others are left")
+		}
+		b.done(inst, true, true)
+	}
+	b.done(tried, true, true)
+
+	// With every instance ejected, or every one excluded, calls are still made
+	b.mutex.Lock()
+	for _, inst := range b.instances {
+		inst.ejectedUntil = time.Now().Add(time.Minute)
+	}
+	b.mutex.Unlock()
+	inst := b.pick(b.instances...)
+	if inst == nil {
+		t.Fatal("no instance picked")
+	}
+	b.done(inst, true, true)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// Batch prediction errors
+var (
+	// ErrInvalidBatch is returned for a batch without items or with too many
+	ErrInvalidBatch = errors.New("invalid batch")
+	// ErrInvalidBatchItem is the error of a batch item that is not a valid prediction request
+	ErrInvalidBatchItem = errors.New("invalid prediction request")
+)
+
+// PredictBatch makes a prediction for every item of a batch with a bounded pool of workers. Every item
+// is predicted and recorded like a single prediction, and its result or error is returned in input order.
+func (s *service) PredictBatch(ctx context.Context, identity *model.Identity, request *model.BatchPredictionRequest, noCache bool) (*model.BatchPredictionResponse, error) {
+	log.Printf("Service: Making batch prediction of %d items by user: %s, minimal: %t", len(request.Items), identity.UserID, request.Minimal)
+	return s.predictBatch(ctx, len(request.Items), func(ctx context.Context, index int) model.BatchPredictionItem {
+		return s.predictBatchItem(ctx, identity, request.Minimal, noCache, index, request.Items[index])
+	})
+}
+
+// PredictRequests makes a prediction for every request like PredictBatch, for callers that decoded the requests already
+func (s *service) PredictRequests(ctx context.Context, identity *model.Identity, requests []model.PredictionRequest, noCache bool) (*model.BatchPredictionResponse, error) {
+	log.Printf("Service: Making batch prediction of %d requests by user: %s", len(requests), identity.UserID)
+	return s.predictBatch(ctx, len(requests), func(ctx context.Context, index int) model.BatchPredictionItem {
+		result, err := s.Predict(ctx, identity, &requests[index], noCache)
+		if err != nil {
+			return model.BatchPredictionItem{Index: index, Err: err}
+		}
+		return model.BatchPredictionItem{Index: index, Status: http.StatusOK, Result: result}
+	})
+}
+
+// predictBatch predicts the items of a batch with a bounded pool of workers and returns their outcomes in input order
+func (s *service) predictBatch(ctx context.Context, count int, predict func(ctx context.Context, index int) model.BatchPredictionItem) (*model.BatchPredictionResponse, error) {
+	if count == 0 {
+		return nil, fmt.Errorf("%w: no items", ErrInvalidBatch)
+	}
+	if count > s.config.Batch.MaxItems {
+		return nil, fmt.Errorf("%w: %d items, at most %d are allowed", ErrInvalidBatch, count, s.config.Batch.MaxItems)
+	}
+
+	results := make([]model.BatchPredictionItem, count)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(s.config.Batch.Concurrency, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = predict(ctx, i)
+			}
+		}()
+	}
+
+	// Items are no longer handed out once the caller has gone away
+	fed := 0
+feed:
+	for ; fed < count; fed++ {
+		select {
+		case indexes <- fed:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	for i := fed; i < count; i++ {
+		results[i] = model.BatchPredictionItem{Index: i, Err: ctx.Err()}
+	}
+
+	response := &model.BatchPredictionResponse{Results: results}
+	for _, result := range results {
+		if result.Err != nil {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+
+	log.Printf("Service: Batch prediction done, succeeded: %d, failed: %d", response.Succeeded, response.Failed)
+	return response, nil
+}
+
+// predictBatchItem decodes and predicts an item of a batch
+func (s *service) predictBatchItem(ctx context.Context, identity *model.Identity, minimal, noCache bool, index int, item json.RawMessage) model.BatchPredictionItem {
+	var result *model.PredictionResult
+	var err error
+	if minimal {
+		var request model.PredictionRequestMinimal
+		if err := json.Unmarshal(item, &request); err != nil {
+			return model.BatchPredictionItem{Index: index, Err: fmt.Errorf("%w: %v", ErrInvalidBatchItem, err)}
+		}
+		result, err = s.PredictMinimal(ctx, identity, &request, noCache)
+	} else {
+		var request model.PredictionRequest
+		if err := json.Unmarshal(item, &request); err != nil {
+			return model.BatchPredictionItem{Index: index, Err: fmt.Errorf("%w: %v", ErrInvalidBatchItem, err)}
+		}
+		result, err = s.Predict(ctx, identity, &request, noCache)
+	}
+
+	if err != nil {
+		return model.BatchPredictionItem{Index: index, Err: err}
+	}
+	return model.BatchPredictionItem{Index: index, Status: http.StatusOK, Result: result}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// BulkheadFullError is returned without calling an upstream service while it has no free slot
+type BulkheadFullError struct {
+	Service string
+}
+
+// Error implements the error interface
+func (e *BulkheadFullError) Error() string {
+	return fmt.Sprintf("%s service is at capacity, too many concurrent requests", strings.ToLower(e.Service))
+}
+
+// bulkhead bounds the concurrent calls to an upstream service, so a burst of calls to one
+// service cannot take up the goroutines and connections the others need. Calls over the limit
+// wait in a bounded queue for a while and are rejected when it is full or the wait times out.
+type bulkhead struct {
+	name   string
+	config config.BulkheadConfig
+	slots  chan struct{}
+
+	mutex         sync.Mutex
+	queued        int
+	admittedTotal uint64
+	rejectedTotal uint64
+}
+
+// newBulkhead creates a bulkhead, nil when the limit is disabled
+func newBulkhead(name string, cfg config.BulkheadConfig) *bulkhead {
+	if cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &bulkhead{
+		name:   name,
+		config: cfg,
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// acquire takes a slot, waiting in the queue if needed. It returns a BulkheadFullError when no slot
+// frees up in time and the context error when the caller goes away. Every acquired slot has to be released.
+func (b *bulkhead) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		b.mutex.Lock()
+		b.admittedTotal++
+		b.mutex.Unlock()
+		return nil
+	default:
+	}
+
+	b.mutex.Lock()
+	if b.queued >= b.config.MaxQueued {
+		b.rejectedTotal++
+		b.mutex.Unlock()
+		log.Printf("Service: Rejecting request to %s service, %d requests in flight and %d queued", b.name, cap(b.slots), b.config.MaxQueued)
+		return &BulkheadFullError{Service: b.name}
+	}
+	b.queued++
+	b.mutex.Unlock()
+
+	timer := time.NewTimer(b.config.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = &BulkheadFullError{Service: b.name}
+		log.Printf("Service: Rejecting request to %s service, no free slot after %v", b.name, b.config.QueueTimeout)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.queued--
+	switch err.(type) {
+	case nil:
+		b.admittedTotal++
+	case *BulkheadFullError:
+		b.rejectedTotal++
+	}
+	return err
+}
+
+// release gives back an acquired slot
+func (b *bulkhead) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// status returns the occupancy and counters of the bulkhead, nil when the limit is disabled
+func (b *bulkhead) status() *model.BulkheadStatus {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return &model.BulkheadStatus{
+		MaxConcurrent: cap(b.slots),
+		MaxQueued:     b.config.MaxQueued,
+		Active:        len(b.slots),
+		Queued:        b.queued,
+		AdmittedTotal: b.admittedTotal,
+		RejectedTotal: b.rejectedTotal,
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+// waitQueued waits until the bulkhead has the number of queued calls
+func waitQueued(t *testing.T, b *bulkhead, queued int) {
+	t.Helper()
+	waitFor(t, "queued calls", func() bool { return b.status().Queued == queued })
+}
+
+func TestBulkheadAdmitsQueuesAndRejects(t *testing.T) {
+	b := newBulkhead("ML", config.BulkheadConfig{MaxConcurrent: 2, MaxQueued: 1, QueueTimeout: time.Minute})
+
+	// Calls up to the limit are admitted right away
+	for range 2 {
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	}
+
+	// The next call waits in the queue for a slot
+	queued := make(chan error, 1)
+	go func() { queued <- b.acquire(context.Background()) }()
+	waitQueued(t, b, 1)
+
+	// A full queue rejects further calls
+	var fullErr *BulkheadFullError
+	if err := b.acquire(context.Background()); !errors.As(err, &fullErr) {
+		t.Fatalf("acquire with a full queue = %v, want a BulkheadFullError", err)
+	}
+
+	// A released slot goes to the queued call
+	b.release()
+	if err := <-queued; err != nil {
+		t.Fatalf("queued acquire: %v", err)
+	}
+
+	status := b.status()
+	if status.Active != 2 || status.Queued != 0 || status.AdmittedTotal != 3 || status.RejectedTotal != 1 {
+		t.Errorf("status = %+v, want 2 active, 0 queued, 3 admitted and 1 rejected", *status)
+	}
+}
+
+func TestBulkheadQueueTimeoutAndCancellation(t *testing.T) {
+	b := newBulkhead("ML", config.BulkheadConfig{MaxConcurrent: 1, MaxQueued: 2, QueueTimeout: 10 * time.Millisecond})
+	if err := b.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// No slot frees up in time
+	var fullErr *BulkheadFullError
+	if err := b.acquire(context.Background()); !errors.As(err, &fullErr) {
+		t.Fatalf("acquire after the queue timeout = %v, want a BulkheadFullError", err)
+	}
+
+	// A caller going away leaves the queue without counting as rejected
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.config.QueueTimeout = time.Minute
+	if err := b.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire of a cancelled caller = %v, want context.Canceled", err)
+	}
+
+	status := b.status()
+	if status.Active != 1 || status.Queued != 0 || status.RejectedTotal != 1 {
+		t.Errorf("status = %+v, want 1 active, 0 queued and 1 rejected", *status)
+	}
+}
+
+func TestBulkheadDisabled(t *testing.T) {
+	b := newBulkhead("ML", config.BulkheadConfig{})
+	for range 100 {
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	}
+	b.release()
+	if status := b.status(); status != nil {
+		t.Errorf("status = %+v, want none", *status)
+	}
+}
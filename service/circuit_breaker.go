@@ -0,0 +1,176 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// CircuitOpenError is returned without calling an upstream service while its circuit is open
+type CircuitOpenError struct {
+	Service    string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s service is unavailable, circuit breaker is open", strings.ToLower(e.Service))
+}
+
+// circuitBreaker stops calling an upstream service once too many calls fail. It is closed while
+// the failure rate of the current window stays under the threshold, open for OpenTimeout once it is
+// exceeded, and then half-open, letting a few probes through to decide whether to close again.
+// Every state change starts a new generation, and the outcome of a call only moves the breaker when
+// the call was allowed in the current one: a slow call allowed while closed is not a half-open probe.
+type circuitBreaker struct {
+	name   string
+	config config.CircuitBreakerConfig
+
+	mutex       sync.Mutex
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	probesOK    int
+	generation  uint64
+
+	successesTotal uint64
+	failuresTotal  uint64
+	rejectedTotal  uint64
+	openedTotal    uint64
+}
+
+// newCircuitBreaker creates a closed circuit breaker
+func newCircuitBreaker(name string, cfg config.CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		name:        name,
+		config:      cfg,
+		state:       model.CircuitClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// allow returns a CircuitOpenError if the call must not be made. Every allowed call has to be
+// followed by a record of its outcome or a release, with the generation it was allowed in.
+func (b *circuitBreaker) allow() (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if b.state == model.CircuitOpen {
+		if retryAfter := b.openedAt.Add(b.config.OpenTimeout).Sub(now); retryAfter > 0 {
+			b.rejectedTotal++
+			return 0, &CircuitOpenError{Service: b.name, RetryAfter: retryAfter}
+		}
+		b.transition(model.CircuitHalfOpen, now)
+	}
+
+	if b.state == model.CircuitHalfOpen {
+		if b.probes >= b.config.HalfOpenRequests {
+			b.rejectedTotal++
+			return 0, &CircuitOpenError{Service: b.name, RetryAfter: time.Second}
+		}
+		b.probes++
+	}
+
+	return b.generation, nil
+}
+
+// record counts the outcome of an allowed call and moves the breaker to the next state,
+// unless the breaker changed state since the call was allowed
+func (b *circuitBreaker) record(generation uint64, success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if success {
+		b.successesTotal++
+	} else {
+		b.failuresTotal++
+	}
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case model.CircuitHalfOpen:
+		// A single failed probe reopens the circuit, enough successful ones close it
+		if !success {
+			b.transition(model.CircuitOpen, now)
+			return
+		}
+		b.probesOK++
+		if b.probesOK >= b.config.HalfOpenRequests {
+			b.transition(model.CircuitClosed, now)
+		}
+	case model.CircuitClosed:
+		if now.Sub(b.windowStart) > b.config.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests && float64(b.failures)/float64(b.requests) >= b.config.FailureRate {
+			b.transition(model.CircuitOpen, now)
+		}
+	}
+}
+
+// release gives back the probe slot of an allowed call without counting its outcome,
+// the slot is already gone when the breaker changed state since the call was allowed
+func (b *circuitBreaker) release(generation uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if generation == b.generation && b.state == model.CircuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// transition moves the breaker to a state and resets the counters of the previous one
+func (b *circuitBreaker) transition(state string, now time.Time) {
+	log.Printf("Service: %s circuit breaker %s -> %s, window: %d requests, %d failures", b.name, b.state, state, b.requests, b.failures)
+
+	b.state = state
+	b.generation++
+	b.probes = 0
+	b.probesOK = 0
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	if state == model.CircuitOpen {
+		b.openedAt = now
+		b.openedTotal++
+	}
+}
+
+// status returns the state and counters of the breaker
+func (b *circuitBreaker) status() model.CircuitBreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := model.CircuitBreakerStatus{
+		State:          b.state,
+		Requests:       b.requests,
+		Failures:       b.failures,
+		SuccessesTotal: b.successesTotal,
+		FailuresTotal:  b.failuresTotal,
+		RejectedTotal:  b.rejectedTotal,
+		OpenedTotal:    b.openedTotal,
+	}
+	if b.state != model.CircuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
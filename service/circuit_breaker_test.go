@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+func testCircuitBreaker() *circuitBreaker {
+	return newCircuitBreaker("ML", config.CircuitBreakerConfig{
+		FailureRate:      0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		OpenTimeout:      time.Minute,
+		HalfOpenRequests: 2,
+	})
+}
+
+// allowCall allows a call on the breaker, failing the test when it is rejected
+func allowCall(t *testing.T, b *circuitBreaker) uint64 {
+	t.Helper()
+	generation, err := b.allow()
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	return generation
+}
+
+// expectRejected checks that the breaker rejects calls with a CircuitOpenError
+func expectRejected(t *testing.T, b *circuitBreaker) {
+	t.Helper()
+	var openErr *CircuitOpenError
+	if _, err := b.allow(); !errors.As(err, &openErr) {
+		t.Fatalf("allow = %v, want a CircuitOpenError", err)
+	}
+}
+
+// openCircuit fails enough calls to open the breaker
+func openCircuit(t *testing.T, b *circuitBreaker) {
+	t.Helper()
+	for range b.config.MinRequests {
+		b.record(allowCall(t, b), false)
+	}
+	if state := b.status().State; state != model.CircuitOpen {
+		t.Fatalf("state = %s, want %s", state, model.CircuitOpen)
+	}
+}
+
+// expireOpenTimeout makes the open breaker ready to let probes through
+func expireOpenTimeout(b *circuitBreaker) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.openedAt = time.Now().Add(-b.config.OpenTimeout)
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	b := testCircuitBreaker()
+
+	// Failures under the rate keep the circuit closed
+	for _, success := range []bool{true, true, false, true} {
+		b.record(allowCall(t, b), success)
+	}
+	if state := b.status().State; state != model.CircuitClosed {
+		t.Fatalf("state = %s, want %s", state, model.CircuitClosed)
+	}
+
+	// Failures over the rate open it, and it rejects calls until the open timeout passes
+	b = testCircuitBreaker()
+	openCircuit(t, b)
+	expectRejected(t, b)
+
+	// Half-open, it lets HalfOpenRequests probes through at a time
+	expireOpenTimeout(b)
+	first := allowCall(t, b)
+	second := allowCall(t, b)
+	expectRejected(t, b)
+	if state := b.status().State; state != model.CircuitHalfOpen {
+		t.Fatalf("state = %s, want %s", state, model.CircuitHalfOpen)
+	}
+
+	// A released probe gives its slot back
+	b.release(second)
+	second = allowCall(t, b)
+
+	// Enough successful probes close it
+	b.record(first, true)
+	b.record(second, true)
+	if state := b.status().State; state != model.CircuitClosed {
+		t.Fatalf("state = %s, want %s", state, model.CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerFailedProbeReopens(t *testing.T) {
+	b := testCircuitBreaker()
+	openCircuit(t, b)
+	expireOpenTimeout(b)
+
+	b.record(allowCall(t, b), false)
+	if state := b.status().State; state != model.CircuitOpen {
+		t.Fatalf("state = %s, want %s", state, model.CircuitOpen)
+	}
+	expectRejected(t, b)
+}
+
+func TestCircuitBreakerIgnoresCallsOfEarlierStates(t *testing.T) {
+	b := testCircuitBreaker()
+
+	// Slow calls allowed while the circuit is closed outlive it
+	slowSuccess := allowCall(t, b)
+	slowReleased := allowCall(t, b)
+	openCircuit(t, b)
+	expireOpenTimeout(b)
+	probe := allowCall(t, b)
+
+	// Their outcomes are not probes: the circuit neither closes nor gives back a probe slot
+	b.record(slowSuccess, true)
+	b.release(slowReleased)
+	second := allowCall(t, b)
+	expectRejected(t, b)
+	if state := b.status().State; state != model.CircuitHalfOpen {
+		t.Fatalf("state = %s, want %s", state, model.CircuitHalfOpen)
+	}
+
+	// Only the real probes close it, and a call of the half-open state is not counted once closed
+	b.record(probe, true)
+	b.record(second, true)
+	if state := b.status().State; state != model.CircuitClosed {
+		t.Fatalf("state = %s, want %s", state, model.CircuitClosed)
+	}
+	b.record(probe, false)
+	if status := b.status(); status.Requests != 0 || status.Failures != 0 {
+		t.Errorf("window = %d requests, %d failures, want none", status.Requests, status.Failures)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// predictionFlights makes a single ML call per prediction key at a time: the callers that ask for a key
+// already in flight wait for its result instead of sending the same request again
+type predictionFlights struct {
+	mutex   sync.Mutex
+	flights map[string]*predictionFlight
+}
+
+// predictionFlight is an ML call shared by the callers waiting for it
+type predictionFlight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	result  *model.PredictionResult
+	err     error
+}
+
+// newPredictionFlights creates an empty group of prediction flights
+func newPredictionFlights() *predictionFlights {
+	return &predictionFlights{flights: map[string]*predictionFlight{}}
+}
+
+// do returns the result of fetch for the key, joining the call in flight for the key if there is one.
+// The call outlives the caller that started it and is only cancelled once every waiting caller went away.
+// Every caller gets its own copy of the result, shared reports whether the call was joined.
+func (g *predictionFlights) do(ctx context.Context, key string, fetch func(ctx context.Context) (*model.PredictionResult, error)) (result *model.PredictionResult, shared bool, err error) {
+	g.mutex.Lock()
+	if flight, exists := g.flights[key]; exists {
+		flight.waiters++
+		g.mutex.Unlock()
+		return g.wait(ctx, key, flight, true)
+	}
+
+	flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	flight := &predictionFlight{done: make(chan struct{}), cancel: cancel, waiters: 1}
+	g.flights[key] = flight
+	g.mutex.Unlock()
+
+	go func() {
+		flight.result, flight.err = fetch(flightCtx)
+
+		g.mutex.Lock()
+		if g.flights[key] == flight {
+			delete(g.flights, key)
+		}
+		g.mutex.Unlock()
+
+		cancel()
+		close(flight.done)
+	}()
+
+	return g.wait(ctx, key, flight, false)
+}
+
+// wait waits for the result of a flight or for the caller to go away
+func (g *predictionFlights) wait(ctx context.Context, key string, flight *predictionFlight, shared bool) (*model.PredictionResult, bool, error) {
+	select {
+	case <-flight.done:
+		if flight.err != nil {
+			return nil, shared, flight.err
+		}
+		result := *flight.result
+		return &result, shared, nil
+	case <-ctx.Done():
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		// The last caller to leave cancels the call, later callers start a new one
+		flight.waiters--
+		if flight.waiters == 0 {
+			if g.flights[key] == flight {
+				delete(g.flights, key)
+			}
+			flight.cancel()
+		}
+		return nil, shared, ctx.Err()
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// dependencyCheck probes a dependency in the background and keeps the latest result
+type dependencyCheck struct {
+	name  string
+	probe func(ctx context.Context) error
+
+	mutex  sync.RWMutex
+	status model.DependencyStatus
+}
+
+// newDependencyCheck creates a check whose dependency is unknown until the first probe.
+// Required dependencies are those the gateway cannot serve any request without.
+func newDependencyCheck(name string, required bool, probe func(ctx context.Context) error) *dependencyCheck {
+	return &dependencyCheck{
+		name:   name,
+		probe:  probe,
+		status: model.DependencyStatus{Name: name, Required: required, Status: model.DependencyUnknown},
+	}
+}
+
+// run probes the dependency once and records the outcome
+func (d *dependencyCheck) run(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	err := d.probe(ctx)
+	checkedAt := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	previous := d.status.Status
+	d.status.LatencyMS = checkedAt.Sub(startTime).Milliseconds()
+	d.status.LastCheckedAt = &checkedAt
+	if err != nil {
+		d.status.Status = model.DependencyDown
+		d.status.LastError = err.Error()
+	} else {
+		d.status.Status = model.DependencyUp
+		d.status.LastSuccessAt = &checkedAt
+	}
+
+	if d.status.Status != previous {
+		log.Printf("Service: Dependency %s is %s, latency: %dms, last error: %q", d.name, d.status.Status, d.status.LatencyMS, d.status.LastError)
+	}
+}
+
+// current returns the latest probe result
+func (d *dependencyCheck) current() model.DependencyStatus {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.status
+}
+
+// startHealthChecks probes every dependency now and then periodically
+func (s *service) startHealthChecks() {
+	s.healthChecks = []*dependencyCheck{
+		newDependencyCheck("postgres", true, s.dbRepo.Ping),
+		// An unavailable upstream service fails its own routes, the others are still served
+		newDependencyCheck("auth", false, func(ctx context.Context) error {
+			return probeUpstream(ctx, s.auth, s.config.HealthCheck.AuthPath)
+		}),
+		newDependencyCheck("ml", false, func(ctx context.Context) error {
+			return probeUpstream(ctx, s.ml, opModelStatus.path)
+		}),
+	}
+
+	runChecks := func() {
+		var wg sync.WaitGroup
+		for _, check := range s.healthChecks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				check.run(s.config.HealthCheck.Timeout)
+			}()
+		}
+		wg.Wait()
+	}
+
+	go func() {
+		runChecks()
+		ticker := time.NewTicker(s.config.HealthCheck.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runChecks()
+		}
+	}()
+}
+
+// GetDependencyStatus returns the latest probe result of every dependency
+func (s *service) GetDependencyStatus(ctx context.Context) []model.DependencyStatus {
+	statuses := make([]model.DependencyStatus, 0, len(s.healthChecks))
+	for _, check := range s.healthChecks {
+		statuses = append(statuses, check.current())
+	}
+	return statuses
+}
+
+// probeUpstream sends a GET request to every instance of an upstream service, bypassing its
+// circuit breaker so recovery is noticed. The service is up if any instance answers with 2xx.
+func probeUpstream(ctx context.Context, u *upstream, path string) error {
+	var lastErr error
+	for _, inst := range u.balancer.instances {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, inst.url(path), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := u.client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", inst.address, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: status code %d", inst.address, resp.StatusCode)
+	}
+	return lastErr
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// Latency samples kept per hedged operation and required before the p95 delay is used
+const (
+	hedgeLatencySamples    = 200
+	hedgeMinLatencySamples = 20
+)
+
+// hedger tracks the latency of the hedged operations of an upstream service and counts the hedges
+type hedger struct {
+	config config.HedgeConfig
+
+	mutex       sync.Mutex
+	latencies   map[string]*latencyWindow
+	hedgesTotal uint64
+	winsTotal   uint64
+}
+
+// newHedger creates a hedger, nil when hedging is disabled or the service has a single instance
+func newHedger(cfg config.HedgeConfig, instances int) *hedger {
+	if !cfg.Enabled || instances < 2 {
+		return nil
+	}
+	return &hedger{config: cfg, latencies: map[string]*latencyWindow{}}
+}
+
+// delay returns how long an attempt of the operation runs before it is hedged, false while it is unknown
+func (h *hedger) delay(op operation) (time.Duration, bool) {
+	if h.config.Delay > 0 {
+		return h.config.Delay, true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	window, exists := h.latencies[op.name]
+	if !exists || len(window.samples) < hedgeMinLatencySamples {
+		return 0, false
+	}
+	return max(window.percentile(0.95), h.config.MinDelay), true
+}
+
+// observe records the latency of a successful attempt of the operation
+func (h *hedger) observe(op operation, latency time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	window, exists := h.latencies[op.name]
+	if !exists {
+		window = &latencyWindow{}
+		h.latencies[op.name] = window
+	}
+	window.add(latency)
+}
+
+// hedged counts an extra attempt sent for a slow call
+func (h *hedger) hedged() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.hedgesTotal++
+}
+
+// won counts a call answered by one of its extra attempts
+func (h *hedger) won() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.winsTotal++
+}
+
+// status returns the current delays and the hedge counters, nil when hedging is disabled
+func (h *hedger) status() *model.HedgingStatus {
+	if h == nil {
+		return nil
+	}
+
+	h.mutex.Lock()
+	names := make([]string, 0, len(h.latencies))
+	for name := range h.latencies {
+		names = append(names, name)
+	}
+	status := &model.HedgingStatus{
+		DelaysMS:    map[string]int64{},
+		HedgesTotal: h.hedgesTotal,
+		WinsTotal:   h.winsTotal,
+	}
+	h.mutex.Unlock()
+
+	for _, name := range names {
+		if delay, ok := h.delay(operation{name: name}); ok {
+			status.DelaysMS[name] = delay.Milliseconds()
+		}
+	}
+	return status
+}
+
+// latencyWindow keeps the latest latencies of an operation in a ring buffer
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+// add records a latency, replacing the oldest one once the window is full
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.samples) < hedgeLatencySamples {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % hedgeLatencySamples
+}
+
+// percentile returns the latency under which the given share of the samples fall
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	sorted := slices.Clone(w.samples)
+	slices.Sort(sorted)
+	return sorted[min(int(float64(len(sorted))*p), len(sorted)-1)]
+}
+
+// attemptResult is the outcome of one attempt of a hedged call
+type attemptResult struct {
+	statusCode int
+	body       []byte
+	err        error
+	hedge      bool
+}
+
+// hedgedAttempt makes an attempt of an idempotent operation and, while it is slower than the hedging
+// delay, sends up to MaxHedges more to other instances. The first success is used and the attempts
+// still in flight are cancelled, which does not count against the instances or the circuit breaker.
+func (s *service) hedgedAttempt(ctx context.Context, u *upstream, op operation, payload interface{}, identity *model.Identity) (int, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var tried instanceSet
+	results := make(chan attemptResult, 1+u.hedger.config.MaxHedges)
+	launch := func(hedge bool) {
+		go func() {
+			startTime := time.Now()
+			statusCode, body, err := s.attempt(ctx, u, op, payload, identity, &tried)
+			if err == nil && statusCode < http.StatusInternalServerError {
+				u.hedger.observe(op, time.Since(startTime))
+			}
+			results <- attemptResult{statusCode: statusCode, body: body, err: err, hedge: hedge}
+		}()
+	}
+
+	launch(false)
+	inFlight, hedges := 1, 0
+
+	// Without a known delay the call is not hedged, its latency still feeds the p95 delay
+	var hedgeTimer <-chan time.Time
+	if delay, ok := u.hedger.delay(op); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	var last attemptResult
+	for inFlight > 0 {
+		select {
+		case result := <-results:
+			inFlight--
+			if result.err == nil && result.statusCode < http.StatusInternalServerError {
+				if result.hedge {
+					u.hedger.won()
+				}
+				return result.statusCode, result.body, result.err
+			}
+			last = result
+		case <-hedgeTimer:
+			hedges++
+			inFlight++
+			u.hedger.hedged()
+			log.Printf("Service: Hedging %s %s, hedge %d of %d", u.name, op.name, hedges, u.hedger.config.MaxHedges)
+			launch(true)
+
+			if hedges < u.hedger.config.MaxHedges {
+				delay, _ := u.hedger.delay(op)
+				hedgeTimer = time.After(delay)
+			} else {
+				hedgeTimer = nil
+			}
+		}
+	}
+	return last.statusCode, last.body, last.err
+}
+
+// instanceSet collects the instances picked by the attempts of a hedged call
+type instanceSet struct {
+	mutex     sync.Mutex
+	instances []*instance
+}
+
+// add records a picked instance
+func (s *instanceSet) add(inst *instance) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.instances = append(s.instances, inst)
+}
+
+// list returns the picked instances
+func (s *instanceSet) list() []*instance {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.instances)
+}
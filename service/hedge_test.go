@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+// hedgeTestUpstream creates an upstream over the endpoints that hedges after delay, at most maxHedges
+// times. It ejects an instance on its first counted failure, so a miscounted hedge shows.
+func hedgeTestUpstream(delay time.Duration, maxHedges int, endpoints ...config.Endpoint) (*service, *upstream) {
+	cfg := &config.Config{
+		CircuitBreaker: config.CircuitBreakerConfig{FailureRate: 0.5, MinRequests: 1, Window: time.Minute, OpenTimeout: time.Minute, HalfOpenRequests: 1},
+		Ejection:       config.EjectionConfig{ConsecutiveFailures: 1, Duration: time.Minute},
+		Hedge:          config.HedgeConfig{Enabled: true, Delay: delay, MaxHedges: maxHedges},
+	}
+	u := newUpstream("ML", "ml-service", config.ServiceConfig{Endpoints: endpoints}, cfg)
+	return &service{config: cfg}, u
+}
+
+func TestHedgedAttemptFirstSuccessWins(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	aborted := make(chan struct{}, 1)
+	slow := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("slow"))
+		}
+	})
+	fast := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	// Round-robin sends the first attempt to the slow instance
+	s, u := hedgeTestUpstream(delay, 1, slow, fast)
+
+	startTime := time.Now()
+	statusCode, body, err := s.hedgedAttempt(context.Background(), u, opPredict, nil, nil)
+	elapsed := time.Since(startTime)
+	if err != nil || statusCode != http.StatusOK || string(body) != "fast" {
+		t.Fatalf("hedgedAttempt = %d %q %v, want 200 from the fast instance", statusCode, body, err)
+	}
+	if elapsed < delay {
+		t.Errorf("answered after %v, before the hedging delay of %v", elapsed, delay)
+	}
+
+	// The losing attempt is cancelled without counting against the instance or the circuit
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow attempt not cancelled")
+	}
+	waitFor(t, "the slow attempt to be given back", func() bool {
+		return u.balancer.status()[0].Outstanding == 0
+	})
+	slowStatus := u.balancer.status()[0]
+	if slowStatus.EjectedUntil != nil || slowStatus.ConsecutiveFailures != 0 {
+		t.Errorf("slow instance = %+v, want no failure counted", slowStatus)
+	}
+	if breaker := u.breaker.status(); breaker.FailuresTotal != 0 || breaker.SuccessesTotal != 1 {
+		t.Errorf("circuit breaker counted %d failures and %d successes, want 0 and 1", breaker.FailuresTotal, breaker.SuccessesTotal)
+	}
+	if hedging := u.hedger.status(); hedging.HedgesTotal != 1 || hedging.WinsTotal != 1 {
+		t.Errorf("hedges = %d, wins = %d, want 1 and 1", hedging.HedgesTotal, hedging.WinsTotal)
+	}
+}
+
+func TestHedgedAttemptRespectsMaxHedges(t *testing.T) {
+	const delay = 10 * time.Millisecond
+	var calls atomic.Int32
+	slowHandler := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(20 * delay)
+		w.Write([]byte("slow"))
+	}
+	s, u := hedgeTestUpstream(delay, 1, startUpstream(t, slowHandler), startUpstream(t, slowHandler))
+
+	// The call outlives many hedging delays, yet sends a single hedge
+	statusCode, _, err := s.hedgedAttempt(context.Background(), u, opPredict, nil, nil)
+	if err != nil || statusCode != http.StatusOK {
+		t.Fatalf("hedgedAttempt = %d %v, want 200", statusCode, err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("upstream calls = %d, want 2", got)
+	}
+	if hedging := u.hedger.status(); hedging.HedgesTotal != 1 {
+		t.Errorf("hedges = %d, want 1", hedging.HedgesTotal)
+	}
+}
+
+func TestHedgerDelay(t *testing.T) {
+	h := newHedger(config.HedgeConfig{Enabled: true, MinDelay: 5 * time.Millisecond, MaxHedges: 1}, 2)
+
+	// The p95 delay is unknown until enough latencies were observed
+	for i := range hedgeMinLatencySamples - 1 {
+		h.observe(opPredict, time.Duration(i+1)*time.Millisecond)
+	}
+	if _, ok := h.delay(opPredict); ok {
+		t.Fatal("delay known before enough samples")
+	}
+	h.observe(opPredict, 20*time.Millisecond)
+	if delay, ok := h.delay(opPredict); !ok || delay != 20*time.Millisecond {
+		t.Errorf("delay = %v %t, want the p95 latency of 20ms", delay, ok)
+	}
+
+	// The delay never drops under MinDelay
+	fast := newHedger(h.config, 2)
+	for range hedgeMinLatencySamples {
+		fast.observe(opPredict, time.Millisecond)
+	}
+	if delay, _ := fast.delay(opPredict); delay != h.config.MinDelay {
+		t.Errorf("delay = %v, want MinDelay %v", delay, h.config.MinDelay)
+	}
+
+	// Hedging needs two instances
+	if newHedger(h.config, 1) != nil {
+		t.Error("hedger created for a single instance")
+	}
+}
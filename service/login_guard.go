@@ -0,0 +1,257 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// Login guard key types
+const (
+	loginKeyEmail = "email"
+	loginKeyIP    = "ip"
+)
+
+// LoginBlockedError is returned when a login attempt is rejected by the brute-force protection
+type LoginBlockedError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("%s, retry in %d seconds", e.Reason, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// loginGuard tracks failed logins per email and per client IP, delaying and locking them out.
+// Attempts in flight count toward the lockout thresholds as if they failed, so parallel attempts
+// cannot all pass the checks before the failures of the first ones are recorded. Only one attempt
+// per email may be in flight, while an IP, which many users may share behind a NAT, takes as many
+// as its threshold allows.
+type loginGuard struct {
+	config   config.LoginGuardConfig
+	mutex    sync.Mutex
+	attempts map[string]*loginAttempts
+}
+
+// loginAttempts is the failed login state of one email or IP
+type loginAttempts struct {
+	keyType     string
+	key         string
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	// inFlight counts the attempts reserved by check and not settled yet
+	inFlight int
+}
+
+// newLoginGuard creates a login guard
+func newLoginGuard(cfg config.LoginGuardConfig) *loginGuard {
+	return &loginGuard{
+		config:   cfg,
+		attempts: make(map[string]*loginAttempts),
+	}
+}
+
+// check returns a LoginBlockedError if the email or the IP is locked out, has to wait after a failure
+// or has too many attempts in flight. Otherwise it reserves the attempt, which must then be settled
+// with recordSuccess, recordFailure or release.
+func (g *loginGuard) check(email, ip string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	g.cleanup(now)
+
+	for _, attempts := range []*loginAttempts{g.attempts[loginKey(loginKeyEmail, email)], g.attempts[loginKey(loginKeyIP, ip)]} {
+		if attempts == nil {
+			continue
+		}
+
+		if attempts.keyType == loginKeyEmail && attempts.inFlight > 0 {
+			return &LoginBlockedError{
+				Reason:     "another login attempt for the same email is in progress",
+				RetryAfter: time.Second,
+			}
+		}
+
+		// Attempts in flight may all fail, they must not take the key past its threshold
+		if maxFailures := g.maxFailures(attempts.keyType); maxFailures > 0 && attempts.failures+attempts.inFlight >= maxFailures {
+			return &LoginBlockedError{
+				Reason:     "too many login attempts in progress for the same " + attempts.keyType,
+				RetryAfter: time.Second,
+			}
+		}
+
+		if now.Before(attempts.lockedUntil) {
+			return &LoginBlockedError{
+				Reason:     "too many failed login attempts, " + attempts.keyType + " is temporarily locked",
+				RetryAfter: attempts.lockedUntil.Sub(now),
+			}
+		}
+
+		if next := attempts.lastFailure.Add(g.delay(attempts.failures)); now.Before(next) {
+			return &LoginBlockedError{
+				Reason:     "login attempted too soon after a failure",
+				RetryAfter: next.Sub(now),
+			}
+		}
+	}
+
+	g.entry(loginKeyEmail, email).inFlight++
+	g.entry(loginKeyIP, ip).inFlight++
+	return nil
+}
+
+// recordFailure settles an attempt that failed, counting it for the email and the IP and locking
+// them out past the thresholds
+func (g *loginGuard) recordFailure(email, ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	g.settle(email, ip)
+	g.fail(loginKeyEmail, email, now)
+	g.fail(loginKeyIP, ip, now)
+}
+
+// release settles an attempt whose outcome says nothing about the credentials, like an upstream error
+func (g *loginGuard) release(email, ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.settle(email, ip)
+}
+
+// settle ends the attempt in flight of the email and the IP
+func (g *loginGuard) settle(email, ip string) {
+	for _, id := range []string{loginKey(loginKeyEmail, email), loginKey(loginKeyIP, ip)} {
+		if attempts, exists := g.attempts[id]; exists && attempts.inFlight > 0 {
+			attempts.inFlight--
+		}
+	}
+}
+
+// entry returns the state of an email or IP, creating it when it is not tracked yet
+func (g *loginGuard) entry(keyType, key string) *loginAttempts {
+	id := loginKey(keyType, key)
+	attempts, exists := g.attempts[id]
+	if !exists {
+		attempts = &loginAttempts{keyType: keyType, key: normalizeLoginKey(key)}
+		g.attempts[id] = attempts
+	}
+	return attempts
+}
+
+// maxFailures returns the failures that lock out a key type, 0 meaning no lockout
+func (g *loginGuard) maxFailures(keyType string) int {
+	if keyType == loginKeyEmail {
+		return g.config.MaxFailuresPerEmail
+	}
+	return g.config.MaxFailuresPerIP
+}
+
+// fail counts a failed login for a single key
+func (g *loginGuard) fail(keyType, key string, now time.Time) {
+	maxFailures := g.maxFailures(keyType)
+	attempts := g.entry(keyType, key)
+	attempts.failures++
+	attempts.lastFailure = now
+	if maxFailures > 0 && attempts.failures >= maxFailures {
+		attempts.lockedUntil = now.Add(g.config.LockoutDuration)
+		log.Printf("Service: Login locked for %s: %s after %d failures until %v", keyType, attempts.key, attempts.failures, attempts.lockedUntil)
+	}
+}
+
+// recordSuccess settles a successful attempt and forgets the failures of the email. IP failures
+// are kept, so one valid account does not reset the counter of an IP trying many others.
+func (g *loginGuard) recordSuccess(email, ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.settle(email, ip)
+	delete(g.attempts, loginKey(loginKeyEmail, email))
+}
+
+// lockouts lists the tracked emails and IPs, most recent failure first
+func (g *loginGuard) lockouts() []model.LoginLockout {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	g.cleanup(now)
+
+	lockouts := []model.LoginLockout{}
+	for _, attempts := range g.attempts {
+		// Entries only tracking an attempt in flight have no failures to report
+		if attempts.failures == 0 {
+			continue
+		}
+		lockout := model.LoginLockout{
+			Type:          attempts.keyType,
+			Key:           attempts.key,
+			Failures:      attempts.failures,
+			LastFailureAt: attempts.lastFailure,
+		}
+		if now.Before(attempts.lockedUntil) {
+			lockedUntil := attempts.lockedUntil
+			lockout.LockedUntil = &lockedUntil
+		}
+		lockouts = append(lockouts, lockout)
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailureAt.After(lockouts[j].LastFailureAt)
+	})
+	return lockouts
+}
+
+// clear forgets the failures of an email or an IP, it reports whether there were any
+func (g *loginGuard) clear(keyType, key string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	id := loginKey(keyType, key)
+	_, exists := g.attempts[id]
+	delete(g.attempts, id)
+	return exists
+}
+
+// delay returns the wait required after the given number of failures
+func (g *loginGuard) delay(failures int) time.Duration {
+	if failures <= 0 || g.config.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := g.config.BaseDelay
+	for i := 1; i < failures && delay < g.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, g.config.MaxDelay)
+}
+
+// cleanup drops the entries that are not locked, have no recent failures and no attempt in flight
+func (g *loginGuard) cleanup(now time.Time) {
+	for id, attempts := range g.attempts {
+		if attempts.inFlight == 0 && now.After(attempts.lockedUntil) && now.Sub(attempts.lastFailure) > g.config.FailureWindow {
+			delete(g.attempts, id)
+		}
+	}
+}
+
+// loginKey builds the map key of an email or IP
+func loginKey(keyType, key string) string {
+	return keyType + ":" + normalizeLoginKey(key)
+}
+
+// normalizeLoginKey makes emails differing only in case or surrounding spaces share their state
+func normalizeLoginKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+func testLoginGuard() *loginGuard {
+	return newLoginGuard(config.LoginGuardConfig{
+		MaxFailuresPerEmail: 3,
+		MaxFailuresPerIP:    10,
+		LockoutDuration:     time.Minute,
+		BaseDelay:           time.Second,
+		MaxDelay:            time.Minute,
+		FailureWindow:       time.Minute,
+	})
+}
+
+func TestLoginGuardAllowsOneAttemptInFlight(t *testing.T) {
+	guard := testLoginGuard()
+
+	// Parallel guesses for one email: only one passes the check
+	var wg sync.WaitGroup
+	results := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- guard.check("victim@example.com", "203.0.113.1")
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	allowed := 0
+	for err := range results {
+		var blocked *LoginBlockedError
+		switch {
+		case err == nil:
+			allowed++
+		case !errors.As(err, &blocked):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if allowed != 1 {
+		t.Fatalf("%d parallel attempts passed the check, want 1", allowed)
+	}
+
+	// After the failure is recorded, the progressive delay applies
+	guard.recordFailure("victim@example.com", "203.0.113.1")
+	if err := guard.check("victim@example.com", "203.0.113.2"); err == nil {
+		t.Error("attempt passed right after a failure")
+	}
+}
+
+func TestLoginGuardSettlesAttempts(t *testing.T) {
+	guard := testLoginGuard()
+
+	// An upstream error releases the attempt without counting a failure
+	if err := guard.check("user@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	guard.release("user@example.com", "203.0.113.1")
+	if err := guard.check("user@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("check after release: %v", err)
+	}
+
+	// A success settles the attempt and forgets the email
+	guard.recordSuccess("user@example.com", "203.0.113.1")
+	if err := guard.check("user@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("check after success: %v", err)
+	}
+	guard.release("user@example.com", "203.0.113.1")
+
+	if lockouts := guard.lockouts(); len(lockouts) != 0 {
+		t.Errorf("lockouts = %+v, want none", lockouts)
+	}
+}
+
+func TestLoginGuardAllowsParallelLoginsFromOneIP(t *testing.T) {
+	guard := testLoginGuard()
+
+	// Two users behind the same NAT log in at the same time
+	if err := guard.check("alice@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("check alice: %v", err)
+	}
+	if err := guard.check("bob@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("check bob while alice logs in: %v", err)
+	}
+	guard.recordSuccess("alice@example.com", "203.0.113.1")
+	guard.recordSuccess("bob@example.com", "203.0.113.1")
+
+	// Parallel guesses over many emails from one IP: only as many as the IP threshold pass
+	var wg sync.WaitGroup
+	results := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- guard.check(fmt.Sprintf("user%d@example.com", i), "203.0.113.2")
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	allowed := 0
+	for err := range results {
+		if err == nil {
+			allowed++
+		}
+	}
+	if allowed != guard.config.MaxFailuresPerIP {
+		t.Errorf("%d parallel attempts from one IP passed the check, want %d", allowed, guard.config.MaxFailuresPerIP)
+	}
+}
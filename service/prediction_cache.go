@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// initialModelVersion identifies the models until the gateway has seen a training job succeed
+const initialModelVersion = "initial"
+
+// loadModelVersion sets the model version to the last succeeded training job, which may have run
+// on another gateway instance
+func (s *service) loadModelVersion(ctx context.Context) {
+	job, err := s.dbRepo.GetLastSucceededTrainingJob(ctx)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("Service: Error loading the model version: %v", err)
+		}
+		return
+	}
+	s.setModelVersion(job.ID.String())
+}
+
+// setModelVersion switches to the models trained by a job, dropping the cached predictions
+// of the previous models when they change
+func (s *service) setModelVersion(version string) {
+	if previous := s.modelVersion.Swap(version); previous != version {
+		log.Printf("Service: Models trained by job %s", version)
+		s.cacheRepo.ClearPredictionResults()
+	}
+}
+
+// predictionIdentity is the identity of the ML calls made for predictions. A call may serve every user
+// waiting for an identical request, so it is made on behalf of the gateway rather than of one of them.
+var predictionIdentity = &model.Identity{Role: "gateway"}
+
+// predict gets a prediction from the response cache or, on a miss or when the caller bypasses
+// the cache, from the ML service. Concurrent identical requests, of any user, share a single ML call
+// made with the gateway identity. Fresh results are cached for the current model version.
+func (s *service) predict(ctx context.Context, op operation, request any, noCache bool) (*model.PredictionResult, error) {
+	key, err := predictionCacheKey(op, s.modelVersion.Load().(string), request)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheEnabled := s.config.PredictionCacheTTL > 0
+	if cacheEnabled && !noCache {
+		if result, found := s.cacheRepo.GetPredictionResult(key); found {
+			log.Printf("Service: Prediction cache hit for %s", op.name)
+			result.CacheStatus = model.CacheHit
+			return result, nil
+		}
+	}
+
+	result, shared, err := s.predictions.do(ctx, key, func(ctx context.Context) (*model.PredictionResult, error) {
+		result, err := s.fetchPrediction(ctx, op, request, predictionIdentity)
+		if err == nil && cacheEnabled {
+			s.cacheRepo.SavePredictionResult(key, *result)
+		}
+		return result, err
+	})
+	if shared {
+		log.Printf("Service: Joined the %s call in flight for an identical request", op.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheEnabled {
+		result.CacheStatus = model.CacheMiss
+		if noCache {
+			result.CacheStatus = model.CacheBypass
+		}
+	}
+	return result, nil
+}
+
+// fetchPrediction gets a prediction from the ML service
+func (s *service) fetchPrediction(ctx context.Context, op operation, request any, identity *model.Identity) (*model.PredictionResult, error) {
+	// Send request to ML service
+	statusCode, body, err := s.send(ctx, s.ml, op, request, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check response status
+	if statusCode != http.StatusOK {
+		return nil, upstreamError(s.ml, statusCode, body)
+	}
+
+	// Unmarshal response
+	var result model.PredictionResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Printf("Service: Error unmarshaling response: %v", err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// predictionCacheKey hashes the canonical JSON of a prediction request with its operation and the
+// model version. Marshaling the typed request fixes the field order and the number formatting,
+// so requests that differ only in their encoding share a key.
+func predictionCacheKey(op operation, modelVersion string, request any) (string, error) {
+	if minimal, ok := request.(*model.PredictionRequestMinimal); ok && minimal.PredictionDate != nil {
+		canonical := *minimal
+		predictionDate := minimal.PredictionDate.UTC()
+		canonical.PredictionDate = &predictionDate
+		request = &canonical
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return op.name + ":" + modelVersion + ":" + hex.EncodeToString(sum[:]), nil
+}
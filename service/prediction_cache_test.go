@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/repository"
+)
+
+// predictionStore counts the predictions saved per user
+type predictionStore struct {
+	repository.DBRepository
+
+	mutex sync.Mutex
+	saved map[uuid.UUID]int
+}
+
+func (s *predictionStore) SavePrediction(_ context.Context, userID uuid.UUID, _ interface{}, _ *model.PredictionResult, _ bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.saved[userID]++
+	return nil
+}
+
+func (s *predictionStore) savedPredictions() map[uuid.UUID]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return maps.Clone(s.saved)
+}
+
+// waitFor polls the condition until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescedPredictionsShareOneCallAcrossUsers(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	var callers []string
+	endpoint := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		callers = append(callers, r.Header.Get(model.HeaderUserRole))
+		mutex.Unlock()
+		<-release
+		w.Write([]byte(`{"predicted_price":1,"predicted_sales":1}`))
+	})
+	// The calls held by the upstream must be released before it is closed
+	unblock := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(unblock)
+
+	cfg := &config.Config{CacheSize: 10, CircuitBreaker: config.CircuitBreakerConfig{HalfOpenRequests: 1}}
+	cacheRepo, err := repository.NewCacheRepository(cfg)
+	if err != nil {
+		t.Fatalf("NewCacheRepository: %v", err)
+	}
+	store := &predictionStore{saved: map[uuid.UUID]int{}}
+	s := &service{
+		config:      cfg,
+		dbRepo:      store,
+		cacheRepo:   cacheRepo,
+		ml:          newUpstream("ML", "ml-service", config.ServiceConfig{Endpoints: []config.Endpoint{endpoint}}, cfg),
+		predictions: newPredictionFlights(),
+	}
+	s.modelVersion.Store(initialModelVersion)
+
+	alice := &model.Identity{UserID: uuid.New(), Role: "user"}
+	bob := &model.Identity{UserID: uuid.New(), Role: "user"}
+	request := &model.PredictionRequestMinimal{ProductName: "P", Region: "R", Seller: "S"}
+
+	var wg sync.WaitGroup
+	for _, identity := range []*model.Identity{alice, bob} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.PredictMinimal(context.Background(), identity, request, false); err != nil {
+				t.Errorf("PredictMinimal: %v", err)
+			}
+		}()
+	}
+	// The request of bob joins the call in flight for the request of alice, or the other way round
+	key, err := predictionCacheKey(opPredictMinimal, initialModelVersion, request)
+	if err != nil {
+		t.Fatalf("predictionCacheKey: %v", err)
+	}
+	waitFor(t, "both requests to wait for one call", func() bool {
+		s.predictions.mutex.Lock()
+		defer s.predictions.mutex.Unlock()
+		flight := s.predictions.flights[key]
+		return flight != nil && flight.waiters == 2
+	})
+	unblock()
+	wg.Wait()
+
+	if len(callers) != 1 || callers[0] != predictionIdentity.Role {
+		t.Errorf("upstream calls made as %v, want a single call as %q", callers, predictionIdentity.Role)
+	}
+	want := map[uuid.UUID]int{alice.UserID: 1, bob.UserID: 1}
+	waitFor(t, "the prediction of every user to be saved", func() bool {
+		return maps.Equal(store.savedPredictions(), want)
+	})
+}
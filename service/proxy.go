@@ -0,0 +1,124 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// statusRecorder captures the status code written by the reverse proxy
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Proxy forwards a request as it is to an instance of an upstream service under the given path,
+// with the caller identity headers. Like typed calls it is load balanced and guarded by the bulkhead
+// and the circuit breaker. The call is bounded by timeout, or by the upstream total and response header
+// timeouts when it is 0. An error is returned, and nothing is written, when the upstream could not be reached.
+func (s *service) Proxy(ctx context.Context, upstreamName, path string, timeout time.Duration, identity *model.Identity, w http.ResponseWriter, r *http.Request) error {
+	u, exists := s.upstreams[upstreamName]
+	if !exists {
+		return fmt.Errorf("unknown upstream: %s", upstreamName)
+	}
+
+	identityHeaders := http.Header{}
+	if err := s.setIdentityHeaders(identityHeaders, u, identity); err != nil {
+		log.Printf("Service: Error setting identity headers: %v", err)
+		return err
+	}
+
+	if err := u.bulkhead.acquire(ctx); err != nil {
+		return err
+	}
+	defer u.bulkhead.release()
+
+	generation, err := u.breaker.allow()
+	if err != nil {
+		log.Printf("Service: Rejecting proxied request to %s service: %v", u.name, err)
+		return err
+	}
+	inst := u.balancer.pick()
+
+	// A route timeout replaces both upstream timeouts, the headers may take as long as the whole call
+	responseHeaderTimeout := u.timeouts.ResponseHeader
+	if timeout > 0 {
+		responseHeaderTimeout = 0
+	}
+	if timeout = cmp.Or(timeout, u.timeouts.Total); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx, cancelHeaders, stopHeaderTimer := withResponseHeaderTimeout(ctx, responseHeaderTimeout)
+	defer cancelHeaders(nil)
+
+	var proxyErr error
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = inst.address
+			pr.Out.URL.Path = path
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = inst.address
+			pr.SetXForwarded()
+			for name, values := range identityHeaders {
+				pr.Out.Header[name] = values
+			}
+		},
+		Transport: u.client.Transport,
+		ModifyResponse: func(*http.Response) error {
+			stopHeaderTimer()
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyErr = responseHeaderError(ctx, u, err)
+		},
+	}
+
+	// The instance and the circuit are settled when the proxy panics with http.ErrAbortHandler too,
+	// which it does when copying the response body fails, like when the client goes away
+	recorder := &statusRecorder{ResponseWriter: w}
+	served := false
+	defer func() {
+		// A call abandoned midway says nothing about the health of the upstream
+		if !served || (proxyErr != nil && errors.Is(proxyErr, context.Canceled)) {
+			u.balancer.done(inst, false, false)
+			u.breaker.release(generation)
+			return
+		}
+
+		success := proxyErr == nil && recorder.statusCode < http.StatusInternalServerError
+		u.balancer.done(inst, true, success)
+		u.breaker.record(generation, success)
+	}()
+
+	log.Printf("Service: Proxying %s %s to %s service: %s%s", r.Method, r.URL.Path, u.name, inst.address, path)
+	proxy.ServeHTTP(recorder, r.WithContext(ctx))
+	served = true
+
+	if proxyErr != nil {
+		log.Printf("Service: Error proxying request to %s service: %v", u.name, proxyErr)
+		return proxyErr
+	}
+	log.Printf("Service: %s service responded to proxied request with status code: %d", u.name, recorder.statusCode)
+	return nil
+}
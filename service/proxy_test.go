@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// brokenClientWriter is a client connection that fails once the response body is written
+type brokenClientWriter struct {
+	*httptest.ResponseRecorder
+}
+
+// Write implements http.ResponseWriter
+func (w brokenClientWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+// startUpstream starts an upstream service instance answering with the handler
+func startUpstream(t *testing.T, handler http.HandlerFunc) config.Endpoint {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parse server URL: %v", err)
+	}
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatalf("split server address: %v", err)
+	}
+	return config.Endpoint{Host: host, Port: port}
+}
+
+func TestProxySettlesCallWhenClientGoesAway(t *testing.T) {
+	endpoint := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("report line\n", 1024)))
+	})
+
+	cfg := &config.Config{CircuitBreaker: config.CircuitBreakerConfig{HalfOpenRequests: 1}}
+	u := newUpstream("ML", "ml-service", config.ServiceConfig{Endpoints: []config.Endpoint{endpoint}}, cfg)
+	s := &service{config: cfg, upstreams: map[string]*upstream{"ml": u}}
+	// The only probe of a half-open circuit would be lost if the call was not settled
+	u.breaker.state = model.CircuitHalfOpen
+
+	// The reverse proxy only aborts the handler of requests served by an http.Server
+	ctx := context.WithValue(context.Background(), http.ServerContextKey, &http.Server{})
+	request := httptest.NewRequest(http.MethodGet, "/reports", nil).WithContext(ctx)
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", recovered)
+			}
+		}()
+		s.Proxy(ctx, "ml", "/reports", 0, nil, brokenClientWriter{httptest.NewRecorder()}, request)
+	}()
+
+	if outstanding := u.balancer.status()[0].Outstanding; outstanding != 0 {
+		t.Errorf("outstanding calls = %d, want 0", outstanding)
+	}
+	if _, err := u.breaker.allow(); err != nil {
+		t.Errorf("half-open circuit probe not given back: %v", err)
+	}
+}
+
+func TestProxyRouteTimeoutBoundsResponseHeaders(t *testing.T) {
+	endpoint := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cfg := &config.Config{CircuitBreaker: config.CircuitBreakerConfig{MinRequests: 10, Window: time.Minute}}
+	u := newUpstream("ML", "ml-service", config.ServiceConfig{
+		Endpoints: []config.Endpoint{endpoint},
+		Timeouts:  config.TimeoutConfig{ResponseHeader: 20 * time.Millisecond, Total: time.Second},
+	}, cfg)
+	s := &service{config: cfg, upstreams: map[string]*upstream{"ml": u}}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{"upstream timeouts", 0, true},
+		{"route timeout", time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			err := s.Proxy(context.Background(), "ml", "/reports", tt.timeout, nil, recorder, httptest.NewRequest(http.MethodGet, "/reports", nil))
+			if tt.wantErr && err == nil {
+				t.Error("Proxy succeeded, want a response header timeout")
+			}
+			if !tt.wantErr && (err != nil || recorder.Code != http.StatusOK) {
+				t.Errorf("Proxy status = %d, error = %v, want %d", recorder.Code, err, http.StatusOK)
+			}
+		})
+	}
+}
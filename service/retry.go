@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// send calls an upstream service on behalf of the caller. Idempotent operations are retried
+// with jittered exponential backoff on network errors and 502, 503 and 504 responses,
+// as long as the attempts and backoffs fit in the retry budget and the caller has not gone away.
+// The budget is stretched to the total timeout of the operation, so the first attempt gets all of it.
+func (s *service) send(ctx context.Context, u *upstream, op operation, payload interface{}, identity *model.Identity) (int, []byte, error) {
+	cfg := s.config.Retry
+	if !op.retry || cfg.MaxAttempts <= 1 {
+		return s.call(ctx, u, op, payload, identity)
+	}
+
+	_, totalTimeout := u.operationTimeouts(op)
+	ctx, cancel := context.WithTimeout(ctx, max(cfg.Budget, totalTimeout))
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	for attempt := 1; ; attempt++ {
+		statusCode, body, err := s.call(ctx, u, op, payload, identity)
+		if attempt >= cfg.MaxAttempts || !retryable(ctx, statusCode, err) {
+			return statusCode, body, err
+		}
+
+		delay := s.backoff(attempt)
+		if time.Now().Add(delay).After(deadline) {
+			log.Printf("Service: Retry budget of %s %s exhausted after %d attempts", u.name, op.name, attempt)
+			return statusCode, body, err
+		}
+
+		log.Printf("Service: Retrying %s %s in %v after attempt %d, status code: %d, error: %v", u.name, op.name, delay, attempt, statusCode, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a failed attempt may succeed when repeated
+func retryable(ctx context.Context, statusCode int, err error) bool {
+	if err != nil {
+		// An attempt that timed out on its own is retried while the budget lasts
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ctx.Err() == nil
+		}
+		// Only transport errors are transient, an open circuit or a cancelled call are not
+		var urlErr *url.Error
+		return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+	}
+
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns a random delay below the exponential ceiling for the given attempt
+func (s *service) backoff(attempt int) time.Duration {
+	ceiling := s.config.Retry.BaseDelay
+	for i := 1; i < attempt && ceiling < s.config.Retry.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, s.config.Retry.MaxDelay)
+	return rand.N(ceiling) + 1
+}
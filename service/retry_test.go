@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/graduate-work-mirea/api-gateway/config"
+)
+
+func TestRetryBudgetCoversOperationTimeout(t *testing.T) {
+	endpoint := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	})
+
+	tests := []struct {
+		name     string
+		timeouts config.TimeoutConfig
+	}{
+		{"request timeout", config.TimeoutConfig{Total: time.Second}},
+		{"operation timeout", config.TimeoutConfig{Total: 10 * time.Millisecond, OperationTotal: map[string]time.Duration{opModelStatus.name: time.Second}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The budget is shorter than a single call
+			cfg := &config.Config{
+				Retry:          config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: 20 * time.Millisecond},
+				CircuitBreaker: config.CircuitBreakerConfig{MinRequests: 10, Window: time.Minute},
+			}
+			u := newUpstream("ML", "ml-service", config.ServiceConfig{Endpoints: []config.Endpoint{endpoint}, Timeouts: tt.timeouts}, cfg)
+			s := &service{config: cfg}
+
+			statusCode, _, err := s.send(context.Background(), u, opModelStatus, nil, nil)
+			if err != nil || statusCode != http.StatusOK {
+				t.Errorf("send status = %d, error = %v, want %d", statusCode, err, http.StatusOK)
+			}
+		})
+	}
+}
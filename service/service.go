@@ -1,42 +1,105 @@
 package service
 
 import (
-	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/graduate-work-mirea/api-gateway/config"
 	"github.com/graduate-work-mirea/api-gateway/model"
 	"github.com/graduate-work-mirea/api-gateway/repository"
 )
 
+// apiKeyPrefix marks the plain API keys issued by the gateway
+const apiKeyPrefix = "gw_"
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = repository.ErrNotFound
+
+// ErrRefreshTokenRevoked is returned when a refresh token was issued before all tokens of its user were revoked
+var ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+
+// refreshClaims are the claims of a refresh token the gateway looks at
+type refreshClaims struct {
+	UserID string `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
 // Service represents the business logic of the API Gateway
 type Service interface {
 	// Auth Service
-	RegisterUser(request *model.UserRegisterRequest) (*model.UserRegisterResponse, error)
-	LoginUser(request *model.UserLoginRequest) (*model.UserLoginResponse, error)
+	RegisterUser(ctx context.Context, request *model.UserRegisterRequest) (*model.UserRegisterResponse, error)
+	LoginUser(ctx context.Context, request *model.UserLoginRequest, clientIP string) (*model.UserLoginResponse, error)
+	RefreshToken(ctx context.Context, request *model.RefreshTokenRequest) (*model.RefreshTokenResponse, error)
+	LogoutUser(ctx context.Context, tokenID string, identity *model.Identity, expiresAt time.Time, request *model.LogoutRequest) error
+
+	// Token revocation
+	IsTokenRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) bool
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID) (*model.TokenRevocation, error)
+
+	// API keys
+	CreateAPIKey(ctx context.Context, userID uuid.UUID, request *model.CreateAPIKeyRequest) (*model.CreateAPIKeyResponse, error)
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID uuid.UUID, keyID uuid.UUID) error
+	AuthenticateAPIKey(ctx context.Context, rawKey string) (*model.APIKey, error)
+
+	// Login brute-force protection
+	GetLoginLockouts(ctx context.Context) []model.LoginLockout
+	ClearLoginLockout(ctx context.Context, email, ip string) bool
 
 	// ML Service
-	Predict(userID uuid.UUID, request *model.PredictionRequest) (*model.PredictionResult, error)
-	PredictMinimal(userID uuid.UUID, request *model.PredictionRequestMinimal) (*model.PredictionResult, error)
-	TrainModels() (*model.TrainingResult, error)
-	GetModelStatus() (*model.ModelStatus, error)
+	Predict(ctx context.Context, identity *model.Identity, request *model.PredictionRequest, noCache bool) (*model.PredictionResult, error)
+	PredictMinimal(ctx context.Context, identity *model.Identity, request *model.PredictionRequestMinimal, noCache bool) (*model.PredictionResult, error)
+	PredictBatch(ctx context.Context, identity *model.Identity, request *model.BatchPredictionRequest, noCache bool) (*model.BatchPredictionResponse, error)
+	PredictRequests(ctx context.Context, identity *model.Identity, requests []model.PredictionRequest, noCache bool) (*model.BatchPredictionResponse, error)
+	GetModelStatus(ctx context.Context, identity *model.Identity) (*model.ModelStatus, error)
+
+	// Training jobs
+	StartTrainingJob(ctx context.Context, identity *model.Identity) (*model.TrainingJob, error)
+	GetTrainingJob(ctx context.Context, jobID uuid.UUID) (*model.TrainingJob, error)
+	ListTrainingJobs(ctx context.Context, limit int) ([]model.TrainingJob, error)
 
 	// Statistics
-	GetUserStatistics(userID uuid.UUID) (*model.UserStatistics, error)
+	GetUserStatistics(ctx context.Context, userID uuid.UUID) (*model.UserStatistics, error)
+
+	// Generic reverse proxy
+	Proxy(ctx context.Context, upstreamName, path string, timeout time.Duration, identity *model.Identity, w http.ResponseWriter, r *http.Request) error
+
+	// Health
+	GetUpstreamStatus(ctx context.Context) []model.UpstreamStatus
+	GetDependencyStatus(ctx context.Context) []model.DependencyStatus
 }
 
 type service struct {
 	config     *config.Config
 	dbRepo     repository.DBRepository
 	cacheRepo  repository.CacheRepository
-	httpClient *http.Client
+	auth       *upstream
+	ml         *upstream
+	upstreams  map[string]*upstream
+	loginGuard *loginGuard
+
+	healthChecks []*dependencyCheck
+
+	// instanceID identifies this gateway process as the owner of the training jobs it runs
+	instanceID        string
+	trainingMutex     sync.Mutex
+	activeTrainingJob uuid.UUID
+
+	// modelVersion identifies the trained models in the prediction cache keys
+	modelVersion atomic.Value
+	predictions  *predictionFlights
 }
 
 // NewService creates a new service
@@ -45,16 +108,22 @@ func NewService(cfg *config.Config, dbRepo repository.DBRepository, cacheRepo re
 
 	// Create service instance
 	svc := &service{
-		config:     cfg,
-		dbRepo:     dbRepo,
-		cacheRepo:  cacheRepo,
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+		config:      cfg,
+		dbRepo:      dbRepo,
+		cacheRepo:   cacheRepo,
+		auth:        newUpstream("Auth", "auth-service", cfg.Auth, cfg),
+		ml:          newUpstream("ML", "ml-service", cfg.ML, cfg),
+		loginGuard:  newLoginGuard(cfg.LoginGuard),
+		instanceID:  uuid.NewString(),
+		predictions: newPredictionFlights(),
 	}
 
+	svc.upstreams = map[string]*upstream{"auth": svc.auth, "ml": svc.ml}
+
 	// Populate cache from database on startup
 	log.Println("Service: Populating cache from database")
 	go func() {
-		predictions, err := dbRepo.GetAllPredictions()
+		predictions, err := dbRepo.GetAllPredictions(context.Background())
 		if err != nil {
 			log.Printf("Service: Error loading predictions from database: %v", err)
 			return
@@ -64,48 +133,64 @@ func NewService(cfg *config.Config, dbRepo repository.DBRepository, cacheRepo re
 		log.Printf("Service: Successfully populated cache with predictions for %d users", len(predictions))
 	}()
 
+	// Load revoked tokens before serving requests, then keep them in sync with other instances
+	log.Println("Service: Loading token revocations from database")
+	svc.loadRevocations(context.Background())
+	if cfg.RevocationSyncInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.RevocationSyncInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				svc.loadRevocations(context.Background())
+			}
+		}()
+	}
+
+	// Jobs whose instance went away, like a previous run of this one, are failed now and then
+	log.Printf("Service: Gateway instance ID: %s", svc.instanceID)
+	svc.failAbandonedTrainingJobs(context.Background())
+	if cfg.TrainingStaleAfter > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.TrainingStaleAfter)
+			defer ticker.Stop()
+			for range ticker.C {
+				svc.failAbandonedTrainingJobs(context.Background())
+			}
+		}()
+	}
+
+	// Models trained through another instance replace the cached predictions here too
+	svc.modelVersion.Store(initialModelVersion)
+	svc.loadModelVersion(context.Background())
+	if cfg.ModelVersionSyncInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.ModelVersionSyncInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				svc.loadModelVersion(context.Background())
+			}
+		}()
+	}
+
+	// Probe the dependencies for the readiness check
+	svc.startHealthChecks()
+
 	return svc
 }
 
 // RegisterUser registers a new user
-func (s *service) RegisterUser(request *model.UserRegisterRequest) (*model.UserRegisterResponse, error) {
-	url := fmt.Sprintf("http://%s:%s/auth/register", s.config.Auth.Host, s.config.Auth.Port)
-	log.Printf("Service: Registering user with email: %s at %s", request.Email, url)
-
-	// Marshal request to JSON
-	reqBody, err := json.Marshal(request)
-	if err != nil {
-		log.Printf("Service: Error marshaling register request: %v", err)
-		return nil, err
-	}
+func (s *service) RegisterUser(ctx context.Context, request *model.UserRegisterRequest) (*model.UserRegisterResponse, error) {
+	log.Printf("Service: Registering user with email: %s", request.Email)
 
 	// Send request to Auth service
-	startTime := time.Now()
-	log.Printf("Service: Sending request to Auth service: %s", url)
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
-	if err != nil {
-		log.Printf("Service: Error sending request to Auth service: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-	log.Printf("Service: Auth service responded in %v with status code: %d", time.Since(startTime), resp.StatusCode)
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	statusCode, body, err := s.send(ctx, s.auth, opRegister, request, nil)
 	if err != nil {
-		log.Printf("Service: Error reading response body: %v", err)
 		return nil, err
 	}
 
 	// Check response status
-	if resp.StatusCode != http.StatusCreated {
-		var errResp model.ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			log.Printf("Service: Error unmarshaling error response: %v, status code: %d", err, resp.StatusCode)
-			return nil, fmt.Errorf("auth service error: %d", resp.StatusCode)
-		}
-		log.Printf("Service: Auth service returned error: %s", errResp.Error)
-		return nil, errors.New(errResp.Error)
+	if statusCode != http.StatusCreated {
+		return nil, upstreamError(s.auth, statusCode, body)
 	}
 
 	// Unmarshal response
@@ -120,44 +205,35 @@ func (s *service) RegisterUser(request *model.UserRegisterRequest) (*model.UserR
 }
 
 // LoginUser logs in a user
-func (s *service) LoginUser(request *model.UserLoginRequest) (*model.UserLoginResponse, error) {
-	url := fmt.Sprintf("http://%s:%s/auth/login", s.config.Auth.Host, s.config.Auth.Port)
-	log.Printf("Service: Logging in user with email: %s at %s", request.Email, url)
+func (s *service) LoginUser(ctx context.Context, request *model.UserLoginRequest, clientIP string) (*model.UserLoginResponse, error) {
+	log.Printf("Service: Logging in user with email: %s from %s", request.Email, clientIP)
 
-	// Marshal request to JSON
-	reqBody, err := json.Marshal(request)
-	if err != nil {
-		log.Printf("Service: Error marshaling login request: %v", err)
+	// Reject locked out or too frequent attempts before they reach the Auth service
+	if err := s.loginGuard.check(request.Email, clientIP); err != nil {
+		log.Printf("Service: Login blocked for email: %s from %s: %v", request.Email, clientIP, err)
 		return nil, err
 	}
 
 	// Send request to Auth service
-	startTime := time.Now()
-	log.Printf("Service: Sending request to Auth service: %s", url)
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	statusCode, body, err := s.send(ctx, s.auth, opLogin, request, nil)
 	if err != nil {
-		log.Printf("Service: Error sending request to Auth service: %v", err)
+		s.loginGuard.release(request.Email, clientIP)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	log.Printf("Service: Auth service responded in %v with status code: %d", time.Since(startTime), resp.StatusCode)
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Service: Error reading response body: %v", err)
-		return nil, err
+	// Settle the attempt reserved by check, counting rejected credentials towards the lockout
+	switch statusCode {
+	case http.StatusOK:
+		s.loginGuard.recordSuccess(request.Email, clientIP)
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		s.loginGuard.recordFailure(request.Email, clientIP)
+	default:
+		s.loginGuard.release(request.Email, clientIP)
 	}
 
 	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		var errResp model.ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			log.Printf("Service: Error unmarshaling error response: %v, status code: %d", err, resp.StatusCode)
-			return nil, fmt.Errorf("auth service error: %d", resp.StatusCode)
-		}
-		log.Printf("Service: Auth service returned error: %s", errResp.Error)
-		return nil, errors.New(errResp.Error)
+	if statusCode != http.StatusOK {
+		return nil, upstreamError(s.auth, statusCode, body)
 	}
 
 	// Unmarshal response
@@ -171,53 +247,238 @@ func (s *service) LoginUser(request *model.UserLoginRequest) (*model.UserLoginRe
 	return &response, nil
 }
 
-// Predict makes a prediction using the ML service
-func (s *service) Predict(userID uuid.UUID, request *model.PredictionRequest) (*model.PredictionResult, error) {
-	url := fmt.Sprintf("http://%s:%s/api/v1/predict", s.config.ML.Host, s.config.ML.Port)
-	log.Printf("Service: Making prediction for product: %s by user: %s at %s", request.ProductName, userID, url)
+// GetLoginLockouts lists the emails and IPs with failed logins
+func (s *service) GetLoginLockouts(ctx context.Context) []model.LoginLockout {
+	return s.loginGuard.lockouts()
+}
+
+// ClearLoginLockout forgets the failed logins of an email and/or an IP, it reports whether there were any
+func (s *service) ClearLoginLockout(ctx context.Context, email, ip string) bool {
+	cleared := false
+	if email != "" {
+		log.Printf("Service: Clearing login lockout for email: %s", email)
+		cleared = s.loginGuard.clear(loginKeyEmail, email) || cleared
+	}
+	if ip != "" {
+		log.Printf("Service: Clearing login lockout for IP: %s", ip)
+		cleared = s.loginGuard.clear(loginKeyIP, ip) || cleared
+	}
+	return cleared
+}
 
-	// Marshal request to JSON
-	reqBody, err := json.Marshal(request)
+// RefreshToken exchanges a refresh token for a new token pair
+func (s *service) RefreshToken(ctx context.Context, request *model.RefreshTokenRequest) (*model.RefreshTokenResponse, error) {
+	log.Println("Service: Refreshing token")
+
+	// Refresh tokens outlive access tokens, so a revocation of all tokens of a user must stop them too
+	if s.refreshTokenRevoked(request.RefreshToken) {
+		log.Println("Service: Refresh token has been revoked")
+		return nil, ErrRefreshTokenRevoked
+	}
+
+	// Send request to Auth service
+	statusCode, body, err := s.send(ctx, s.auth, opRefresh, request, nil)
 	if err != nil {
-		log.Printf("Service: Error marshaling predict request: %v", err)
 		return nil, err
 	}
 
-	// Send request to ML service
-	startTime := time.Now()
-	log.Printf("Service: Sending request to ML service: %s", url)
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	// Check response status
+	if statusCode != http.StatusOK {
+		return nil, upstreamError(s.auth, statusCode, body)
+	}
+
+	// Unmarshal response
+	var response model.RefreshTokenResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.Printf("Service: Error unmarshaling response: %v", err)
+		return nil, err
+	}
+
+	log.Println("Service: Token refreshed successfully")
+	return &response, nil
+}
+
+// refreshTokenRevoked reports whether a refresh token was issued to a user before all their tokens were revoked.
+// The claims are read without verifying the signature, which is left to the Auth service: a forged token
+// can only get itself rejected here. Tokens without an iat claim are revoked by any revocation of their user.
+func (s *service) refreshTokenRevoked(refreshToken string) bool {
+	claims := &refreshClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(refreshToken, claims); err != nil {
+		return false
+	}
+
+	userID, err := uuid.Parse(cmp.Or(claims.UserID, claims.Subject))
+	if err != nil {
+		return false
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return s.cacheRepo.IsTokenRevoked(claims.ID, userID, issuedAt)
+}
+
+// LogoutUser revokes the access token locally and the refresh token in the Auth service
+func (s *service) LogoutUser(ctx context.Context, tokenID string, identity *model.Identity, expiresAt time.Time, request *model.LogoutRequest) error {
+	// Revoke the access token first so it stops working even if the Auth service call fails
+	log.Printf("Service: Revoking access token of user: %s until %v", identity.UserID, expiresAt)
+	if err := s.dbRepo.RevokeToken(ctx, tokenID, identity.UserID, expiresAt); err != nil {
+		log.Printf("Service: Error saving revoked token to database: %v", err)
+		return err
+	}
+	s.cacheRepo.RevokeToken(tokenID, expiresAt)
+
+	// Send request to Auth service
+	statusCode, body, err := s.send(ctx, s.auth, opLogout, request, identity)
+	if err != nil {
+		return err
+	}
+
+	// Check response status
+	if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
+		return upstreamError(s.auth, statusCode, body)
+	}
+
+	log.Println("Service: User logged out successfully")
+	return nil
+}
+
+// IsTokenRevoked checks whether an access token has been revoked
+func (s *service) IsTokenRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) bool {
+	return s.cacheRepo.IsTokenRevoked(tokenID, userID, issuedAt)
+}
+
+// RevokeUserTokens revokes every token issued to a user so far
+func (s *service) RevokeUserTokens(ctx context.Context, userID uuid.UUID) (*model.TokenRevocation, error) {
+	revokedBefore := time.Now()
+	log.Printf("Service: Revoking all tokens of user: %s issued before %v", userID, revokedBefore)
+
+	if err := s.dbRepo.RevokeUserTokens(ctx, userID, revokedBefore); err != nil {
+		log.Printf("Service: Error saving user revocation to database: %v", err)
+		return nil, err
+	}
+	s.cacheRepo.RevokeUserTokens(userID, revokedBefore)
+
+	return &model.TokenRevocation{
+		UserID:        userID,
+		RevokedBefore: revokedBefore,
+	}, nil
+}
+
+// loadRevocations replaces the cached revocation state with the one stored in the database
+func (s *service) loadRevocations(ctx context.Context) {
+	tokens, err := s.dbRepo.GetRevokedTokens(ctx)
 	if err != nil {
-		log.Printf("Service: Error sending request to ML service: %v", err)
+		log.Printf("Service: Error loading revoked tokens from database: %v", err)
+		return
+	}
+
+	users, err := s.dbRepo.GetUserTokenRevocations(ctx)
+	if err != nil {
+		log.Printf("Service: Error loading user revocations from database: %v", err)
+		return
+	}
+
+	s.cacheRepo.PopulateRevocations(tokens, users)
+}
+
+// CreateAPIKey issues a new API key for a user, only its hash is stored
+func (s *service) CreateAPIKey(ctx context.Context, userID uuid.UUID, request *model.CreateAPIKeyRequest) (*model.CreateAPIKeyResponse, error) {
+	log.Printf("Service: Creating API key %q for user: %s with scopes: %v", request.Name, userID, request.Scopes)
+
+	// Generate the plain key
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Service: Error generating API key: %v", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	log.Printf("Service: ML service responded in %v with status code: %d", time.Since(startTime), resp.StatusCode)
+	rawKey := apiKeyPrefix + hex.EncodeToString(secret)
+
+	apiKey := model.APIKey{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      request.Name,
+		Prefix:    rawKey[:len(apiKeyPrefix)+8],
+		Scopes:    request.Scopes,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.dbRepo.CreateAPIKey(ctx, &apiKey, hashAPIKey(rawKey)); err != nil {
+		log.Printf("Service: Error saving API key to database: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Service: API key created with ID: %s", apiKey.ID)
+	return &model.CreateAPIKeyResponse{
+		APIKey: apiKey,
+		Key:    rawKey,
+	}, nil
+}
+
+// ListAPIKeys lists the API keys of a user
+func (s *service) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error) {
+	log.Printf("Service: Listing API keys for user: %s", userID)
+	return s.dbRepo.ListAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey revokes an API key of a user
+func (s *service) RevokeAPIKey(ctx context.Context, userID uuid.UUID, keyID uuid.UUID) error {
+	log.Printf("Service: Revoking API key: %s of user: %s", keyID, userID)
+	if err := s.dbRepo.RevokeAPIKey(ctx, userID, keyID); err != nil {
+		log.Printf("Service: Error revoking API key: %v", err)
+		return err
+	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	s.cacheRepo.DeleteAPIKey(keyID)
+	return nil
+}
+
+// AuthenticateAPIKey looks up the active API key matching a plain key
+func (s *service) AuthenticateAPIKey(ctx context.Context, rawKey string) (*model.APIKey, error) {
+	keyHash := hashAPIKey(rawKey)
+	if apiKey, found := s.cacheRepo.GetAPIKey(keyHash); found {
+		return apiKey, nil
+	}
+
+	apiKey, err := s.dbRepo.GetAPIKeyByHash(ctx, keyHash)
 	if err != nil {
-		log.Printf("Service: Error reading response body: %v", err)
 		return nil, err
 	}
+	s.cacheRepo.SaveAPIKey(keyHash, apiKey)
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		var errResp model.ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			log.Printf("Service: Error unmarshaling error response: %v, status code: %d", err, resp.StatusCode)
-			return nil, fmt.Errorf("ml service error: %d", resp.StatusCode)
+	// Lookups hit the database once per cache TTL, which is precise enough for the last use time
+	go func() {
+		if err := s.dbRepo.TouchAPIKey(context.WithoutCancel(ctx), apiKey.ID, time.Now()); err != nil {
+			log.Printf("Service: Error updating API key last use time: %v", err)
 		}
-		log.Printf("Service: ML service returned error: %s", errResp.Error)
-		return nil, errors.New(errResp.Error)
+	}()
+
+	return apiKey, nil
+}
+
+// hashAPIKey hashes a plain API key for storage and lookup
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Predict makes a prediction using the ML service
+func (s *service) Predict(ctx context.Context, identity *model.Identity, request *model.PredictionRequest, noCache bool) (*model.PredictionResult, error) {
+	userID := identity.UserID
+	log.Printf("Service: Making prediction for product: %s by user: %s", request.ProductName, userID)
+
+	// Reject invalid requests before they reach the cache or the ML service
+	if err := validationError(request.Validate()); err != nil {
+		return nil, err
 	}
 
-	// Unmarshal response
-	var result model.PredictionResult
-	if err := json.Unmarshal(body, &result); err != nil {
-		log.Printf("Service: Error unmarshaling response: %v", err)
+	// Get the prediction from the cache or the ML service
+	cachedResult, err := s.predict(ctx, opPredict, request, noCache)
+	if err != nil {
 		return nil, err
 	}
+	result := *cachedResult
 
 	// Create prediction history
 	prediction := model.PredictionHistory{
@@ -235,7 +496,7 @@ func (s *service) Predict(userID uuid.UUID, request *model.PredictionRequest) (*
 		// Save prediction to database
 		go func() {
 			log.Printf("Service: Saving prediction to database for user: %s", userID)
-			if err := s.dbRepo.SavePrediction(userID, request, &result, false); err != nil {
+			if err := s.dbRepo.SavePrediction(context.WithoutCancel(ctx), userID, request, &result, false); err != nil {
 				log.Printf("Service: Error saving prediction to database: %v", err)
 			} else {
 				log.Printf("Service: Prediction saved to database successfully")
@@ -260,42 +521,20 @@ func (s *service) Predict(userID uuid.UUID, request *model.PredictionRequest) (*
 }
 
 // PredictMinimal makes a prediction using the ML service with minimal input
-func (s *service) PredictMinimal(userID uuid.UUID, request *model.PredictionRequestMinimal) (*model.PredictionResult, error) {
-	url := fmt.Sprintf("http://%s:%s/api/v1/predict/minimal", s.config.ML.Host, s.config.ML.Port)
+func (s *service) PredictMinimal(ctx context.Context, identity *model.Identity, request *model.PredictionRequestMinimal, noCache bool) (*model.PredictionResult, error) {
+	userID := identity.UserID
 
-	// Marshal request to JSON
-	reqBody, err := json.Marshal(request)
-	if err != nil {
+	// Reject invalid requests before they reach the cache or the ML service
+	if err := validationError(request.Validate()); err != nil {
 		return nil, err
 	}
 
-	// Send request to ML service
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	// Get the prediction from the cache or the ML service
+	cachedResult, err := s.predict(ctx, opPredictMinimal, request, noCache)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		var errResp model.ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			return nil, fmt.Errorf("ml service error: %d", resp.StatusCode)
-		}
-		return nil, errors.New(errResp.Error)
-	}
-
-	// Unmarshal response
-	var result model.PredictionResult
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
-	}
+	result := *cachedResult
 
 	// Create prediction history
 	prediction := model.PredictionHistory{
@@ -311,7 +550,7 @@ func (s *service) PredictMinimal(userID uuid.UUID, request *model.PredictionRequ
 	if !(result.PredictedPrice == 0 && result.PredictedSales == 0) {
 		// Save prediction to database
 		go func() {
-			if err := s.dbRepo.SavePrediction(userID, request, &result, true); err != nil {
+			if err := s.dbRepo.SavePrediction(context.WithoutCancel(ctx), userID, request, &result, true); err != nil {
 				log.Printf("Error saving prediction to database: %v", err)
 			}
 		}()
@@ -329,65 +568,17 @@ func (s *service) PredictMinimal(userID uuid.UUID, request *model.PredictionRequ
 	return &result, nil
 }
 
-// TrainModels trains the ML models
-func (s *service) TrainModels() (*model.TrainingResult, error) {
-	url := fmt.Sprintf("http://%s:%s/api/v1/train", s.config.ML.Host, s.config.ML.Port)
-
-	// Send request to ML service
-	resp, err := s.httpClient.Post(url, "application/json", nil)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		var errResp model.ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			return nil, fmt.Errorf("ml service error: %d", resp.StatusCode)
-		}
-		return nil, errors.New(errResp.Error)
-	}
-
-	// Unmarshal response
-	var result model.TrainingResult
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
-	}
-
-	return &result, nil
-}
-
 // GetModelStatus gets the status of the ML models
-func (s *service) GetModelStatus() (*model.ModelStatus, error) {
-	url := fmt.Sprintf("http://%s:%s/api/v1/status", s.config.ML.Host, s.config.ML.Port)
-
+func (s *service) GetModelStatus(ctx context.Context, identity *model.Identity) (*model.ModelStatus, error) {
 	// Send request to ML service
-	resp, err := s.httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	statusCode, body, err := s.send(ctx, s.ml, opModelStatus, nil, identity)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		var errResp model.ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			return nil, fmt.Errorf("ml service error: %d", resp.StatusCode)
-		}
-		return nil, errors.New(errResp.Error)
+	if statusCode != http.StatusOK {
+		return nil, upstreamError(s.ml, statusCode, body)
 	}
 
 	// Unmarshal response
@@ -400,7 +591,7 @@ func (s *service) GetModelStatus() (*model.ModelStatus, error) {
 }
 
 // GetUserStatistics gets statistics for a user
-func (s *service) GetUserStatistics(userID uuid.UUID) (*model.UserStatistics, error) {
+func (s *service) GetUserStatistics(ctx context.Context, userID uuid.UUID) (*model.UserStatistics, error) {
 	log.Printf("Service: Getting statistics for user: %s", userID)
 
 	// Try to get predictions from cache first
@@ -416,7 +607,7 @@ func (s *service) GetUserStatistics(userID uuid.UUID) (*model.UserStatistics, er
 
 	// If not in cache, get from database
 	log.Printf("Service: No cache entry found, getting predictions from database for user: %s", userID)
-	predictions, err := s.dbRepo.GetUserPredictions(userID)
+	predictions, err := s.dbRepo.GetUserPredictions(ctx, userID)
 	if err != nil {
 		log.Printf("Service: Error getting predictions from database: %v", err)
 		return nil, err
@@ -428,3 +619,8 @@ func (s *service) GetUserStatistics(userID uuid.UUID) (*model.UserStatistics, er
 		Predictions: predictions,
 	}, nil
 }
+
+// GetUpstreamStatus returns the circuit breaker state of every upstream service
+func (s *service) GetUpstreamStatus(ctx context.Context) []model.UpstreamStatus {
+	return []model.UpstreamStatus{s.auth.status(), s.ml.status()}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/repository"
+)
+
+// ErrTrainingInProgress is returned when a training job is started while another one is still active
+var ErrTrainingInProgress = errors.New("a training job is already in progress")
+
+// StartTrainingJob creates a training job and runs it in the background. A single job is active
+// over all gateway instances, the database refuses a second one.
+func (s *service) StartTrainingJob(ctx context.Context, identity *model.Identity) (*model.TrainingJob, error) {
+	s.trainingMutex.Lock()
+	defer s.trainingMutex.Unlock()
+
+	if s.activeTrainingJob != uuid.Nil {
+		log.Printf("Service: Rejecting training job, job %s is still active", s.activeTrainingJob)
+		return nil, ErrTrainingInProgress
+	}
+
+	// A job left by a gone instance must not block the new one
+	s.failAbandonedTrainingJobs(ctx)
+
+	now := time.Now()
+	job := &model.TrainingJob{
+		ID:          uuid.New(),
+		UserID:      identity.UserID,
+		State:       model.TrainingJobQueued,
+		CreatedAt:   now,
+		Owner:       s.instanceID,
+		HeartbeatAt: &now,
+	}
+	if err := s.dbRepo.SaveTrainingJob(ctx, job); err != nil {
+		if errors.Is(err, repository.ErrTrainingJobActive) {
+			log.Println("Service: Rejecting training job, a job is active on another gateway instance")
+			return nil, ErrTrainingInProgress
+		}
+		return nil, err
+	}
+	s.activeTrainingJob = job.ID
+
+	log.Printf("Service: Training job %s queued by user: %s", job.ID, identity.UserID)
+	go s.runTrainingJob(*job, identity)
+
+	return job, nil
+}
+
+// runTrainingJob trains the models on behalf of a job and records the outcome
+func (s *service) runTrainingJob(job model.TrainingJob, identity *model.Identity) {
+	defer func() {
+		s.trainingMutex.Lock()
+		s.activeTrainingJob = uuid.Nil
+		s.trainingMutex.Unlock()
+	}()
+
+	// Heartbeats tell the other instances that the job is still running
+	stopHeartbeats := make(chan struct{})
+	defer close(stopHeartbeats)
+	go s.sendTrainingHeartbeats(job.ID, stopHeartbeats)
+
+	startedAt := time.Now()
+	job.State = model.TrainingJobRunning
+	job.StartedAt = &startedAt
+	job.HeartbeatAt = &startedAt
+	if err := s.dbRepo.SaveTrainingJob(context.Background(), &job); err != nil {
+		log.Printf("Service: Error saving training job %s: %v", job.ID, err)
+	}
+
+	// The job outlives the request that started it, it is only bounded by the training timeout
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.TrainingTimeout)
+	result, err := s.trainModels(ctx, identity)
+	cancel()
+
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	job.HeartbeatAt = &finishedAt
+	if err != nil {
+		job.State = model.TrainingJobFailed
+		job.Error = err.Error()
+		log.Printf("Service: Training job %s failed after %v: %v", job.ID, finishedAt.Sub(startedAt), err)
+	} else {
+		job.State = model.TrainingJobSucceeded
+		job.Result = result
+		log.Printf("Service: Training job %s succeeded in %v", job.ID, finishedAt.Sub(startedAt))
+
+		// Cached predictions were made by the previous models, other instances see the new ones
+		// when they next load the model version
+		s.setModelVersion(job.ID.String())
+	}
+
+	if err := s.dbRepo.SaveTrainingJob(context.Background(), &job); err != nil {
+		log.Printf("Service: Error saving training job %s: %v", job.ID, err)
+	}
+}
+
+// GetTrainingJob gets a training job by ID
+func (s *service) GetTrainingJob(ctx context.Context, jobID uuid.UUID) (*model.TrainingJob, error) {
+	return s.dbRepo.GetTrainingJob(ctx, jobID)
+}
+
+// ListTrainingJobs lists the most recent training jobs
+func (s *service) ListTrainingJobs(ctx context.Context, limit int) ([]model.TrainingJob, error) {
+	return s.dbRepo.ListTrainingJobs(ctx, limit)
+}
+
+// sendTrainingHeartbeats records that this instance still runs the job until stop is closed
+func (s *service) sendTrainingHeartbeats(jobID uuid.UUID, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.config.TrainingHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := s.dbRepo.TouchTrainingJob(context.Background(), jobID, s.instanceID, now); err != nil {
+				log.Printf("Service: Error recording heartbeat of training job %s: %v", jobID, err)
+			}
+		}
+	}
+}
+
+// failAbandonedTrainingJobs fails the unfinished jobs whose instance stopped sending heartbeats
+func (s *service) failAbandonedTrainingJobs(ctx context.Context) {
+	staleBefore := time.Now().Add(-s.config.TrainingStaleAfter)
+	count, err := s.dbRepo.FailStaleTrainingJobs(ctx, staleBefore, "abandoned by the gateway instance running it")
+	if err != nil {
+		log.Printf("Service: Error failing abandoned training jobs: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Service: Failed %d training jobs without a heartbeat since %s", count, staleBefore.Format(time.RFC3339))
+	}
+}
+
+// trainModels trains the ML models
+func (s *service) trainModels(ctx context.Context, identity *model.Identity) (*model.TrainingResult, error) {
+	// Send request to ML service
+	statusCode, body, err := s.send(ctx, s.ml, opTrain, nil, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check response status
+	if statusCode != http.StatusOK {
+		return nil, upstreamError(s.ml, statusCode, body)
+	}
+
+	// Unmarshal response
+	var result model.TrainingResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+	"github.com/graduate-work-mirea/api-gateway/repository"
+)
+
+// trainingJobStore keeps training jobs in memory and allows a single active one, like the database
+type trainingJobStore struct {
+	repository.DBRepository
+
+	mutex sync.Mutex
+	jobs  map[uuid.UUID]model.TrainingJob
+}
+
+func newTrainingJobStore(jobs ...model.TrainingJob) *trainingJobStore {
+	store := &trainingJobStore{jobs: map[uuid.UUID]model.TrainingJob{}}
+	for _, job := range jobs {
+		store.jobs[job.ID] = job
+	}
+	return store
+}
+
+func activeJob(job model.TrainingJob) bool {
+	return job.State == model.TrainingJobQueued || job.State == model.TrainingJobRunning
+}
+
+func (s *trainingJobStore) SaveTrainingJob(_ context.Context, job *model.TrainingJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if activeJob(*job) {
+		for id, other := range s.jobs {
+			if id != job.ID && activeJob(other) {
+				return repository.ErrTrainingJobActive
+			}
+		}
+	}
+	s.jobs[job.ID] = *job
+	return nil
+}
+
+func (s *trainingJobStore) TouchTrainingJob(_ context.Context, jobID uuid.UUID, owner string, heartbeatAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, exists := s.jobs[jobID]
+	if !exists || job.Owner != owner || !activeJob(job) {
+		return repository.ErrNotFound
+	}
+	job.HeartbeatAt = &heartbeatAt
+	s.jobs[jobID] = job
+	return nil
+}
+
+func (s *trainingJobStore) FailStaleTrainingJobs(_ context.Context, staleBefore time.Time, reason string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var count int64
+	for id, job := range s.jobs {
+		if activeJob(job) && (job.HeartbeatAt == nil || job.HeartbeatAt.Before(staleBefore)) {
+			job.State = model.TrainingJobFailed
+			job.Error = reason
+			s.jobs[id] = job
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *trainingJobStore) GetLastSucceededTrainingJob(context.Context) (*model.TrainingJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var last *model.TrainingJob
+	for _, job := range s.jobs {
+		if job.State == model.TrainingJobSucceeded && (last == nil || job.FinishedAt.After(*last.FinishedAt)) {
+			last = &job
+		}
+	}
+	if last == nil {
+		return nil, repository.ErrNotFound
+	}
+	return last, nil
+}
+
+func (s *trainingJobStore) job(id uuid.UUID) model.TrainingJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.jobs[id]
+}
+
+// trainingService creates a gateway instance sharing the store, training on an ML service that succeeds
+func trainingService(t *testing.T, store *trainingJobStore) *service {
+	t.Helper()
+	endpoint := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+
+	cfg := &config.Config{
+		CacheSize:                 10,
+		TrainingTimeout:           time.Minute,
+		TrainingHeartbeatInterval: 10 * time.Millisecond,
+		TrainingStaleAfter:        time.Minute,
+		PredictionCacheTTL:        time.Minute,
+	}
+	cacheRepo, err := repository.NewCacheRepository(cfg)
+	if err != nil {
+		t.Fatalf("NewCacheRepository: %v", err)
+	}
+	s := &service{
+		config:     cfg,
+		dbRepo:     store,
+		cacheRepo:  cacheRepo,
+		ml:         newUpstream("ML", "ml-service", config.ServiceConfig{Endpoints: []config.Endpoint{endpoint}}, cfg),
+		instanceID: uuid.NewString(),
+	}
+	s.modelVersion.Store(initialModelVersion)
+	return s
+}
+
+// runningJob returns a job running on another instance with its last heartbeat at the time
+func runningJob(heartbeatAt time.Time) model.TrainingJob {
+	return model.TrainingJob{
+		ID:          uuid.New(),
+		UserID:      uuid.New(),
+		State:       model.TrainingJobRunning,
+		CreatedAt:   heartbeatAt,
+		Owner:       uuid.NewString(),
+		HeartbeatAt: &heartbeatAt,
+	}
+}
+
+func TestTrainingJobOfLiveInstanceIsKept(t *testing.T) {
+	job := runningJob(time.Now())
+	store := newTrainingJobStore(job)
+	s := trainingService(t, store)
+
+	s.failAbandonedTrainingJobs(context.Background())
+	if state := store.job(job.ID).State; state != model.TrainingJobRunning {
+		t.Errorf("job of a live instance is %s, want %s", state, model.TrainingJobRunning)
+	}
+
+	if _, err := s.StartTrainingJob(context.Background(), &model.Identity{UserID: uuid.New()}); !errors.Is(err, ErrTrainingInProgress) {
+		t.Errorf("StartTrainingJob error = %v, want %v", err, ErrTrainingInProgress)
+	}
+}
+
+func TestTrainingJobOfGoneInstanceIsFailed(t *testing.T) {
+	job := runningJob(time.Now().Add(-2 * time.Minute))
+	store := newTrainingJobStore(job)
+	s := trainingService(t, store)
+
+	started, err := s.StartTrainingJob(context.Background(), &model.Identity{UserID: uuid.New()})
+	if err != nil {
+		t.Fatalf("StartTrainingJob: %v", err)
+	}
+	if state := store.job(job.ID).State; state != model.TrainingJobFailed {
+		t.Errorf("job of a gone instance is %s, want %s", state, model.TrainingJobFailed)
+	}
+	if owner := store.job(started.ID).Owner; owner != s.instanceID {
+		t.Errorf("new job owner = %q, want %q", owner, s.instanceID)
+	}
+}
+
+func TestTrainingHeartbeats(t *testing.T) {
+	startedAt := time.Now()
+	job := runningJob(startedAt)
+	store := newTrainingJobStore(job)
+	s := trainingService(t, store)
+	s.instanceID = job.Owner
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.sendTrainingHeartbeats(job.ID, stop)
+	}()
+	time.Sleep(5 * s.config.TrainingHeartbeatInterval)
+	close(stop)
+	<-done
+
+	if heartbeatAt := store.job(job.ID).HeartbeatAt; !heartbeatAt.After(startedAt) {
+		t.Errorf("heartbeat at %v, want after %v", heartbeatAt, startedAt)
+	}
+}
+
+func TestTrainedModelsReplaceCachedPredictionsOnEveryInstance(t *testing.T) {
+	store := newTrainingJobStore()
+	trainer := trainingService(t, store)
+	other := trainingService(t, store)
+
+	// Both instances cached a prediction of the initial models
+	request := &model.PredictionRequestMinimal{ProductName: "P", Region: "R", Seller: "S"}
+	key, err := predictionCacheKey(opPredictMinimal, initialModelVersion, request)
+	if err != nil {
+		t.Fatalf("predictionCacheKey: %v", err)
+	}
+	for _, s := range []*service{trainer, other} {
+		s.cacheRepo.SavePredictionResult(key, model.PredictionResult{PredictedPrice: 1})
+	}
+
+	job, err := trainer.StartTrainingJob(context.Background(), &model.Identity{UserID: uuid.New()})
+	if err != nil {
+		t.Fatalf("StartTrainingJob: %v", err)
+	}
+	waitFor(t, "the training job to succeed", func() bool {
+		return store.job(job.ID).State == model.TrainingJobSucceeded
+	})
+
+	// The other instance switches to the new models when it reloads the model version
+	other.loadModelVersion(context.Background())
+	for name, s := range map[string]*service{"trainer": trainer, "other": other} {
+		if version := s.modelVersion.Load(); version != job.ID.String() {
+			t.Errorf("%s model version = %v, want %s", name, version, job.ID)
+		}
+		if _, found := s.cacheRepo.GetPredictionResult(key); found {
+			t.Errorf("%s still serves the prediction of the previous models", name)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/graduate-work-mirea/api-gateway/config"
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// UpstreamError is an error response of an upstream service
+type UpstreamError struct {
+	Service    string
+	StatusCode int
+	Message    string
+}
+
+// Error implements the error interface
+func (e *UpstreamError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s service error: %d", strings.ToLower(e.Service), e.StatusCode)
+}
+
+// errResponseHeaderTimeout is returned when an upstream service sends no response headers in time
+var errResponseHeaderTimeout = fmt.Errorf("timeout awaiting response headers: %w", context.DeadlineExceeded)
+
+// upstream is an external service called by the gateway
+type upstream struct {
+	name     string
+	audience string
+	client   *http.Client
+	timeouts config.TimeoutConfig
+	bulkhead *bulkhead
+	balancer *balancer
+	breaker  *circuitBreaker
+	hedger   *hedger
+}
+
+// operation is a call to an upstream endpoint
+type operation struct {
+	name   string
+	method string
+	path   string
+	// retry allows retrying the call on transient failures, it must only be set for idempotent calls
+	retry bool
+	// hedge allows hedging slow attempts, it must only be set for idempotent calls
+	hedge bool
+	// callerBounded calls are only bounded by the caller and the operation timeouts, not by the service ones
+	callerBounded bool
+}
+
+// Upstream operations
+var (
+	opRegister       = operation{name: "register", method: http.MethodPost, path: "/auth/register"}
+	opLogin          = operation{name: "login", method: http.MethodPost, path: "/auth/login"}
+	opRefresh        = operation{name: "refresh", method: http.MethodPost, path: "/auth/refresh"}
+	opLogout         = operation{name: "logout", method: http.MethodPost, path: "/auth/logout"}
+	opPredict        = operation{name: "predict", method: http.MethodPost, path: "/api/v1/predict", retry: true, hedge: true}
+	opPredictMinimal = operation{name: "predict_minimal", method: http.MethodPost, path: "/api/v1/predict/minimal", retry: true, hedge: true}
+	// Training is expensive and not idempotent, it is never retried, and it runs as long as the training job allows
+	opTrain       = operation{name: "train", method: http.MethodPost, path: "/api/v1/train", callerBounded: true}
+	opModelStatus = operation{name: "model_status", method: http.MethodGet, path: "/api/v1/status", retry: true}
+)
+
+// internalClaims are the claims of the gateway-signed token forwarded to upstream services
+type internalClaims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+	Role   string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// newUpstream creates an upstream service client
+func newUpstream(name, audience string, service config.ServiceConfig, cfg *config.Config) *upstream {
+	return &upstream{
+		name:     name,
+		audience: audience,
+		client:   &http.Client{Transport: newTransport(service.Timeouts, service.Transport)},
+		timeouts: service.Timeouts,
+		bulkhead: newBulkhead(name, service.Bulkhead),
+		balancer: newBalancer(name, service, cfg.Ejection),
+		breaker:  newCircuitBreaker(name, cfg.CircuitBreaker),
+		hedger:   newHedger(cfg.Hedge, len(service.Endpoints)),
+	}
+}
+
+// newTransport creates the pooled transport of an upstream service client. The response header
+// timeout is applied per call, since it differs between operations.
+func newTransport(timeouts config.TimeoutConfig, cfg config.TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   timeouts.Connect,
+		KeepAlive: cfg.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   timeouts.Connect,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// operationTimeouts returns the response header and total timeouts of an operation, 0 meaning none
+func (u *upstream) operationTimeouts(op operation) (responseHeader, total time.Duration) {
+	responseHeader, found := u.timeouts.OperationResponseHeader[op.name]
+	if !found && !op.callerBounded {
+		responseHeader = u.timeouts.ResponseHeader
+	}
+	total, found = u.timeouts.OperationTotal[op.name]
+	if !found && !op.callerBounded {
+		total = u.timeouts.Total
+	}
+	return responseHeader, total
+}
+
+// withResponseHeaderTimeout cancels the returned context with errResponseHeaderTimeout as the cause
+// unless the returned stop function is called, once the response headers arrived, before the timeout
+func withResponseHeaderTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelCauseFunc, func() bool) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if timeout <= 0 {
+		return ctx, cancel, func() bool { return true }
+	}
+	timer := time.AfterFunc(timeout, func() { cancel(errResponseHeaderTimeout) })
+	return ctx, cancel, timer.Stop
+}
+
+// responseHeaderError replaces the cancellation error of a call whose response headers timed out
+func responseHeaderError(ctx context.Context, u *upstream, err error) error {
+	if errors.Is(context.Cause(ctx), errResponseHeaderTimeout) {
+		return fmt.Errorf("%s service: %w", strings.ToLower(u.name), errResponseHeaderTimeout)
+	}
+	return err
+}
+
+// status returns the client-side state of the upstream service
+func (u *upstream) status() model.UpstreamStatus {
+	return model.UpstreamStatus{
+		Name:           u.name,
+		Strategy:       u.balancer.strategy,
+		CircuitBreaker: u.breaker.status(),
+		Bulkhead:       u.bulkhead.status(),
+		Hedging:        u.hedger.status(),
+		Instances:      u.balancer.status(),
+	}
+}
+
+// call makes one attempt of an operation, hedged when the operation and the upstream allow it
+func (s *service) call(ctx context.Context, u *upstream, op operation, payload interface{}, identity *model.Identity) (int, []byte, error) {
+	if op.hedge && u.hedger != nil {
+		return s.hedgedAttempt(ctx, u, op, payload, identity)
+	}
+	return s.attempt(ctx, u, op, payload, identity, nil)
+}
+
+// attempt makes a single call to an upstream service on behalf of the caller and reads the response,
+// on an instance not tried yet when possible. It fails fast while the upstream has no free slot or
+// its circuit is open, network errors and 5xx responses count as failures.
+func (s *service) attempt(ctx context.Context, u *upstream, op operation, payload interface{}, identity *model.Identity, tried *instanceSet) (int, []byte, error) {
+	if err := u.bulkhead.acquire(ctx); err != nil {
+		return 0, nil, err
+	}
+	defer u.bulkhead.release()
+
+	generation, err := u.breaker.allow()
+	if err != nil {
+		log.Printf("Service: Rejecting request to %s service: %v", u.name, err)
+		return 0, nil, err
+	}
+	inst := u.balancer.pick(tried.list()...)
+	tried.add(inst)
+	statusCode, body, err := s.do(ctx, u, inst, op, payload, identity)
+
+	// A call abandoned by the caller says nothing about the health of the upstream
+	if err != nil && errors.Is(err, context.Canceled) {
+		u.balancer.done(inst, false, false)
+		u.breaker.release(generation)
+		return statusCode, body, err
+	}
+
+	success := err == nil && statusCode < http.StatusInternalServerError
+	u.balancer.done(inst, true, success)
+	u.breaker.record(generation, success)
+	return statusCode, body, err
+}
+
+// do sends a JSON request to an instance of an upstream service and reads the response
+func (s *service) do(ctx context.Context, u *upstream, inst *instance, op operation, payload interface{}, identity *model.Identity) (int, []byte, error) {
+	url := inst.url(op.path)
+
+	responseHeaderTimeout, totalTimeout := u.operationTimeouts(op)
+	if totalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, totalTimeout)
+		defer cancel()
+	}
+	ctx, cancelHeaders, stopHeaderTimer := withResponseHeaderTimeout(ctx, responseHeaderTimeout)
+	defer cancelHeaders(nil)
+
+	// Marshal request to JSON
+	var reqBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Service: Error marshaling %s request: %v", u.name, err)
+			return 0, nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, op.method, url, reqBody)
+	if err != nil {
+		log.Printf("Service: Error creating request to %s service: %v", u.name, err)
+		return 0, nil, err
+	}
+	if op.method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := s.setIdentityHeaders(req.Header, u, identity); err != nil {
+		log.Printf("Service: Error setting identity headers: %v", err)
+		return 0, nil, err
+	}
+
+	// Send request to the upstream service
+	startTime := time.Now()
+	log.Printf("Service: Sending request to %s service: %s %s", u.name, op.method, url)
+	resp, err := u.client.Do(req)
+	stopHeaderTimer()
+	if err != nil {
+		err = responseHeaderError(ctx, u, err)
+		log.Printf("Service: Error sending request to %s service: %v", u.name, err)
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	log.Printf("Service: %s service responded in %v with status code: %d", u.name, time.Since(startTime), resp.StatusCode)
+
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		err = responseHeaderError(ctx, u, err)
+		log.Printf("Service: Error reading response body: %v", err)
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+// setIdentityHeaders forwards the authenticated caller to the upstream service, optionally with
+// a token the upstream can verify, signed with the internal token secret and never accepted by the gateway
+func (s *service) setIdentityHeaders(header http.Header, u *upstream, identity *model.Identity) error {
+	if identity == nil {
+		return nil
+	}
+
+	header.Set(model.HeaderUserID, identity.UserID.String())
+	if identity.Role != "" {
+		header.Set(model.HeaderUserRole, identity.Role)
+	}
+	if identity.Email != "" {
+		header.Set(model.HeaderUserEmail, identity.Email)
+	}
+
+	if !s.config.InternalToken.Enabled {
+		return nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, internalClaims{
+		UserID: identity.UserID.String(),
+		Email:  identity.Email,
+		Role:   identity.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.config.InternalToken.Issuer,
+			Subject:   identity.UserID.String(),
+			Audience:  jwt.ClaimStrings{u.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.config.InternalToken.TTL)),
+		},
+	})
+	if s.config.InternalToken.KeyID != "" {
+		token.Header["kid"] = s.config.InternalToken.KeyID
+	}
+
+	signed, err := token.SignedString([]byte(s.config.InternalToken.Secret))
+	if err != nil {
+		return err
+	}
+	header.Set(model.HeaderGatewayToken, signed)
+	return nil
+}
+
+// upstreamError builds the error for an unsuccessful upstream response
+func upstreamError(u *upstream, statusCode int, body []byte) error {
+	var errResp model.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		log.Printf("Service: Error unmarshaling error response: %v, status code: %d", err, statusCode)
+	} else {
+		log.Printf("Service: %s service returned error: %s", u.name, errResp.Error)
+	}
+
+	return &UpstreamError{
+		Service:    u.name,
+		StatusCode: statusCode,
+		Message:    errResp.Error,
+	}
+}
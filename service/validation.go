@@ -0,0 +1,30 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/graduate-work-mirea/api-gateway/model"
+)
+
+// ValidationError is returned when the fields of a prediction request are invalid
+type ValidationError struct {
+	Errors []model.FieldError
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Field + " " + fieldError.Message
+	}
+	return fmt.Sprintf("invalid prediction request: %s", strings.Join(messages, "; "))
+}
+
+// validationError wraps the field errors of a request, it returns nil when there are none
+func validationError(fieldErrors []model.FieldError) error {
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: fieldErrors}
+}